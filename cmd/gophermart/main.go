@@ -1,14 +1,27 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/iurnickita/gophermart/internal/audit"
 	"github.com/iurnickita/gophermart/internal/auth"
 	"github.com/iurnickita/gophermart/internal/config"
+	"github.com/iurnickita/gophermart/internal/grpcserver"
 	"github.com/iurnickita/gophermart/internal/handler"
 	"github.com/iurnickita/gophermart/internal/logger"
+	"github.com/iurnickita/gophermart/internal/outbox"
+	"github.com/iurnickita/gophermart/internal/reconciliation"
+	"github.com/iurnickita/gophermart/internal/referral"
 	"github.com/iurnickita/gophermart/internal/service"
+	"github.com/iurnickita/gophermart/internal/service/accrualclient"
 	"github.com/iurnickita/gophermart/internal/store"
+	"github.com/iurnickita/gophermart/internal/tracing"
+	"github.com/iurnickita/gophermart/internal/webhook"
+	"golang.org/x/sync/errgroup"
 )
 
 func main() {
@@ -19,19 +32,57 @@ func main() {
 
 func run() error {
 	cfg := config.GetConfig()
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	zaplog, levels, err := logger.NewZapLog(cfg.Logger)
+	if err != nil {
+		return err
+	}
+	go toggleDebugOnSignal(levels, zaplog)
 
-	zaplog, err := logger.NewZapLog(cfg.Logger)
+	shutdownTracing, err := tracing.NewTracerProvider(cfg.Tracing)
 	if err != nil {
 		return err
 	}
+	defer shutdownTracing(context.Background())
+
+	store, err := store.NewStore(cfg.Store, levels.Named(logger.ComponentStore))
+	if err != nil {
+		return err
+	}
+
+	audit, err := audit.NewAudit(cfg.Audit, store, zaplog)
+	if err != nil {
+		return err
+	}
+
+	reconciler := reconciliation.NewReconciler(cfg.Reconciliation, store, audit, zaplog)
+	go reconciler.Run(context.Background())
 
-	store, err := store.NewStore(cfg.Store)
+	// уведомления вебхуков пользователей доставляются через outbox
+	webhook := webhook.NewWebhook(cfg.Webhook, store)
+	dispatcher, err := outbox.NewDispatcher(cfg.Outbox, store, zaplog, webhook)
 	if err != nil {
 		return err
 	}
+	go dispatcher.Run(context.Background())
 
-	auth := auth.NewAuth(store)
-	service := service.NewService(cfg.Service, store)
+	referral := referral.NewReferral(cfg.Referral, store)
+	auth := auth.NewAuth(store, referral, audit)
+	accrual := accrualclient.NewAccrualClient(cfg.Service.AccrualAddr, cfg.Service.AccrualClient, levels.Named(logger.ComponentAccrual))
+	service := service.NewService(cfg.Service, store, referral, webhook, accrual, levels.Named(logger.ComponentService))
 
-	return handler.Serve(cfg.Handler, auth, service, zaplog)
+	// HTTP и gRPC серверы работают до сигнала остановки или ошибки любого из них
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	group, ctx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		return handler.Serve(ctx, cfg.Handler, auth, service, audit, levels, levels.Named(logger.ComponentHandler))
+	})
+	group.Go(func() error {
+		return grpcserver.Serve(ctx, cfg.GRPC, auth, service, audit, levels.Named(logger.ComponentGRPC))
+	})
+	return group.Wait()
 }
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import (
+	"github.com/iurnickita/gophermart/internal/logger"
+	"go.uber.org/zap"
+)
+
+// toggleDebugOnSignal - SIGUSR1 есть только в unix-системах
+func toggleDebugOnSignal(logger.Levels, *zap.Logger) {}
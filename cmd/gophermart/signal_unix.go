@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/iurnickita/gophermart/internal/logger"
+	"go.uber.org/zap"
+)
+
+// toggleDebugOnSignal по SIGUSR1 переключает все компоненты в debug и обратно
+func toggleDebugOnSignal(levels logger.Levels, zaplog *zap.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	for range signals {
+		debug := levels.ToggleDebug()
+		zaplog.Warn("log level toggled by SIGUSR1", zap.Bool("debug", debug))
+	}
+}
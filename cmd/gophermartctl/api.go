@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/iurnickita/gophermart/internal/problem"
+)
+
+// adminRequest выполняет запрос к административному API и разбирает ответ в result
+func (c *ctl) adminRequest(ctx context.Context, method string, path string, body any, result any) error {
+	if c.adminToken == "" {
+		return errors.New("admin token required (-t or GOPHERMART_ADMIN_TOKEN)")
+	}
+	var problemJSON problem.Problem
+	resp, err := resty.New().R().
+		SetContext(ctx).
+		SetAuthToken(c.adminToken).
+		SetBody(body).
+		SetResult(result).
+		SetError(&problemJSON).
+		Execute(method, strings.TrimSuffix(c.addr, "/")+path)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() >= http.StatusBadRequest {
+		if problemJSON.Code != "" {
+			return fmt.Errorf("%s: %s (%s)", resp.Status(), problemJSON.Detail, problemJSON.Code)
+		}
+		return errors.New(resp.Status())
+	}
+	return nil
+}
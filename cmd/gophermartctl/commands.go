@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/audit"
+	auditConfig "github.com/iurnickita/gophermart/internal/audit/config"
+	"github.com/iurnickita/gophermart/internal/auth"
+	"github.com/iurnickita/gophermart/internal/handler"
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/reconciliation"
+	reconciliationConfig "github.com/iurnickita/gophermart/internal/reconciliation/config"
+	"github.com/iurnickita/gophermart/internal/referral"
+	referralConfig "github.com/iurnickita/gophermart/internal/referral/config"
+	"github.com/iurnickita/gophermart/internal/store"
+	storeConfig "github.com/iurnickita/gophermart/internal/store/config"
+	"go.uber.org/zap"
+)
+
+// openStore подключается к БД. Недостающие миграции применяются, как при запуске сервиса
+func (c *ctl) openStore() (store.Store, audit.Audit, error) {
+	if c.dsn == "" {
+		return nil, nil, fmt.Errorf("database DSN required (-d or DATABASE_URI)")
+	}
+	store, err := store.NewStore(storeConfig.Config{DBDsn: c.dsn}, zap.NewNop())
+	if err != nil {
+		return nil, nil, err
+	}
+	audit, err := audit.NewAudit(auditConfig.Config{}, store, zap.NewNop())
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, audit, nil
+}
+
+// parseArgs разбирает флаги команды и проверяет число позиционных аргументов
+func parseArgs(flags *flag.FlagSet, args []string, nargs int, names string) ([]string, error) {
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: gophermartctl %s [flags] %s\n", flags.Name(), names)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() != nargs {
+		flags.Usage()
+		return nil, errUsage
+	}
+	return flags.Args(), nil
+}
+
+// findCustomer ищет пользователя по коду, затем по логину
+func findCustomer(ctx context.Context, st store.Store, codeOrLogin string) (model.Customer, error) {
+	customer, err := st.CustomerGet(ctx, codeOrLogin)
+	if err == store.ErrNotFound {
+		customer, err = st.CustomerGetByLogin(ctx, codeOrLogin)
+	}
+	if err == store.ErrNotFound {
+		return model.Customer{}, fmt.Errorf("customer %q not found", codeOrLogin)
+	}
+	return customer, err
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+type customerJSON struct {
+	Code         string    `json:"code"`
+	Login        string    `json:"login"`
+	ReferralCode string    `json:"referral_code"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (c *ctl) printCustomers(customers []model.Customer) error {
+	data := []customerJSON{}
+	var rows [][]string
+	for _, customer := range customers {
+		data = append(data, customerJSON{Code: customer.Code,
+			Login:        customer.Data.Login,
+			ReferralCode: customer.Data.ReferralCode,
+			CreatedAt:    customer.Data.CreatedAt})
+		rows = append(rows, []string{customer.Code, customer.Data.Login, customer.Data.ReferralCode,
+			formatTime(customer.Data.CreatedAt)})
+	}
+	return c.out.print(data, []string{"CODE", "LOGIN", "REFERRAL CODE", "CREATED AT"}, rows)
+}
+
+func (c *ctl) customersList(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("customers list", flag.ContinueOnError)
+	query := flags.String("q", "", "часть логина или код пользователя")
+	limit := flags.Int("limit", 100, "максимальное количество пользователей")
+	if _, err := parseArgs(flags, args, 0, ""); err != nil {
+		return err
+	}
+	st, _, err := c.openStore()
+	if err != nil {
+		return err
+	}
+	customers, err := st.CustomerFind(ctx, model.CustomerFilter{Query: *query, Limit: *limit})
+	if err != nil {
+		return err
+	}
+	return c.printCustomers(customers)
+}
+
+func (c *ctl) customersShow(ctx context.Context, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("customers show", flag.ContinueOnError), args, 1, "<login|code>")
+	if err != nil {
+		return err
+	}
+	st, _, err := c.openStore()
+	if err != nil {
+		return err
+	}
+	customer, err := findCustomer(ctx, st, args[0])
+	if err != nil {
+		return err
+	}
+	return c.printCustomers([]model.Customer{customer})
+}
+
+type balanceJSON struct {
+	Customer  string `json:"customer"`
+	Balance   int    `json:"balance"`
+	OnHold    int    `json:"on_hold"`
+	Current   int    `json:"current"`
+	Withdrawn int    `json:"withdrawn"`
+}
+
+func (c *ctl) balance(ctx context.Context, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("balance", flag.ContinueOnError), args, 1, "<login|code>")
+	if err != nil {
+		return err
+	}
+	st, _, err := c.openStore()
+	if err != nil {
+		return err
+	}
+	customer, err := findCustomer(ctx, st, args[0])
+	if err != nil {
+		return err
+	}
+	balance, err := st.BalanceGetActual(ctx, customer.Code)
+	if err != nil && err != store.ErrNotFound {
+		return err
+	}
+	onHold, err := st.BalanceGetOnHold(ctx, customer.Code)
+	if err != nil {
+		return err
+	}
+	data := balanceJSON{Customer: customer.Code,
+		Balance:   balance.Data.Balance,
+		OnHold:    onHold,
+		Current:   balance.Data.Balance - onHold,
+		Withdrawn: balance.Data.Withdrawn}
+	return c.out.print(data, []string{"CUSTOMER", "BALANCE", "ON HOLD", "CURRENT", "WITHDRAWN"},
+		[][]string{{data.Customer, strconv.Itoa(data.Balance), strconv.Itoa(data.OnHold),
+			strconv.Itoa(data.Current), strconv.Itoa(data.Withdrawn)}})
+}
+
+type ledgerJSON struct {
+	Operation  string    `json:"operation"`
+	Timestamp  time.Time `json:"timestamp"`
+	Kind       string    `json:"kind"`
+	Order      string    `json:"order,omitempty"`
+	Reference  string    `json:"reference,omitempty"`
+	Difference int       `json:"difference"`
+	Balance    int       `json:"balance"`
+	Withdrawn  int       `json:"withdrawn"`
+}
+
+func (c *ctl) ledger(ctx context.Context, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("ledger", flag.ContinueOnError), args, 1, "<login|code>")
+	if err != nil {
+		return err
+	}
+	st, _, err := c.openStore()
+	if err != nil {
+		return err
+	}
+	customer, err := findCustomer(ctx, st, args[0])
+	if err != nil {
+		return err
+	}
+	history, err := st.BalanceGetHistory(ctx, customer.Code)
+	if err != nil {
+		return err
+	}
+	data := []ledgerJSON{}
+	var rows [][]string
+	for _, entry := range history {
+		data = append(data, ledgerJSON{Operation: entry.Key.Operation,
+			Timestamp:  entry.Data.Timestamp,
+			Kind:       entry.Data.Kind,
+			Order:      entry.Data.Order,
+			Reference:  entry.Data.Reference,
+			Difference: entry.Data.Difference,
+			Balance:    entry.Data.Balance,
+			Withdrawn:  entry.Data.Withdrawn})
+		rows = append(rows, []string{entry.Key.Operation, formatTime(entry.Data.Timestamp), entry.Data.Kind,
+			entry.Data.Order, entry.Data.Reference, strconv.Itoa(entry.Data.Difference),
+			strconv.Itoa(entry.Data.Balance), strconv.Itoa(entry.Data.Withdrawn)})
+	}
+	return c.out.print(data, []string{"OPERATION", "TIMESTAMP", "KIND", "ORDER", "REFERENCE", "DIFFERENCE", "BALANCE", "WITHDRAWN"}, rows)
+}
+
+func (c *ctl) printOrders(orders []handler.GetOrderJSONResponse) error {
+	var rows [][]string
+	for _, order := range orders {
+		rows = append(rows, []string{order.Number, order.Status, strconv.Itoa(order.Accrual), formatTime(order.Uploaded_at)})
+	}
+	return c.out.print(orders, []string{"NUMBER", "STATUS", "ACCRUAL", "UPLOADED AT"}, rows)
+}
+
+func (c *ctl) ordersRequeue(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("orders requeue", flag.ContinueOnError)
+	olderThan := flags.Duration("older-than", 0, "минимальный возраст заказа (0 - по умолчанию сервиса)")
+	if _, err := parseArgs(flags, args, 0, ""); err != nil {
+		return err
+	}
+	var response handler.PostOrderRequeueJSONResponse
+	err := c.adminRequest(ctx, http.MethodPost, "/api/admin/orders/requeue",
+		handler.PostOrderRequeueJSONRequest{OlderThan: int(olderThan.Seconds())}, &response)
+	if err != nil {
+		return err
+	}
+	return c.printOrders(response.Requeued)
+}
+
+func (c *ctl) ordersSetStatus(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("orders set-status", flag.ContinueOnError)
+	accrual := flags.Int("accrual", 0, "начисление для статуса PROCESSED")
+	args, err := parseArgs(flags, args, 2, "<number> <status>")
+	if err != nil {
+		return err
+	}
+	var response handler.GetOrderJSONResponse
+	err = c.adminRequest(ctx, http.MethodPut, "/api/admin/orders/"+url.PathEscape(args[0])+"/status",
+		handler.PutOrderStatusJSONRequest{Status: args[1], Accrual: *accrual}, &response)
+	if err != nil {
+		return err
+	}
+	return c.printOrders([]handler.GetOrderJSONResponse{response})
+}
+
+type reconcileJSON struct {
+	Customers  int                       `json:"customers"`
+	Mismatches []reconciliation.Mismatch `json:"mismatches"`
+	Repaired   int                       `json:"repaired"`
+}
+
+func (c *ctl) reconcile(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "исправить расхождения корректирующими записями")
+	if _, err := parseArgs(flags, args, 0, ""); err != nil {
+		return err
+	}
+	st, audit, err := c.openStore()
+	if err != nil {
+		return err
+	}
+	report, err := reconciliation.NewReconciler(reconciliationConfig.Config{}, st, audit, zap.NewNop()).Reconcile(ctx, *repair)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, mismatch := range report.Mismatches {
+		rows = append(rows, []string{mismatch.Customer, mismatch.Kind, mismatch.Operation, mismatch.Order,
+			strconv.Itoa(mismatch.Expected), strconv.Itoa(mismatch.Actual)})
+	}
+	if !c.out.json {
+		fmt.Fprintf(os.Stderr, "customers: %d, mismatches: %d, repaired: %d\n",
+			report.Customers, len(report.Mismatches), report.Repaired)
+	}
+	data := reconcileJSON{Customers: report.Customers, Mismatches: report.Mismatches, Repaired: report.Repaired}
+	if data.Mismatches == nil {
+		data.Mismatches = []reconciliation.Mismatch{}
+	}
+	return c.out.print(data, []string{"CUSTOMER", "KIND", "OPERATION", "ORDER", "EXPECTED", "ACTUAL"}, rows)
+}
+
+type adminJSON struct {
+	Login     string    `json:"login"`
+	CreatedAt time.Time `json:"created_at"`
+	// Токен показывается только при создании
+	Token string `json:"token,omitempty"`
+}
+
+func (c *ctl) adminsCreate(ctx context.Context, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("admins create", flag.ContinueOnError), args, 1, "<login>")
+	if err != nil {
+		return err
+	}
+	st, audit, err := c.openStore()
+	if err != nil {
+		return err
+	}
+	auth := auth.NewAuth(st, referral.NewReferral(referralConfig.Config{}, st), audit)
+	adminToken, err := auth.CreateAdmin(ctx, args[0])
+	recordAudit(ctx, audit, model.AuditActionAdminCreate, args[0], err)
+	if err != nil {
+		return err
+	}
+	data := adminJSON{Login: args[0], CreatedAt: time.Now(), Token: adminToken}
+	return c.out.print(data, []string{"LOGIN", "TOKEN"}, [][]string{{data.Login, data.Token}})
+}
+
+func (c *ctl) adminsList(ctx context.Context, args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("admins list", flag.ContinueOnError), args, 0, ""); err != nil {
+		return err
+	}
+	st, _, err := c.openStore()
+	if err != nil {
+		return err
+	}
+	admins, err := st.AdminUserGet(ctx)
+	if err != nil {
+		return err
+	}
+	data := []adminJSON{}
+	var rows [][]string
+	for _, admin := range admins {
+		data = append(data, adminJSON{Login: admin.Login, CreatedAt: admin.Data.CreatedAt})
+		rows = append(rows, []string{admin.Login, formatTime(admin.Data.CreatedAt)})
+	}
+	return c.out.print(data, []string{"LOGIN", "CREATED AT"}, rows)
+}
+
+// recordAudit записывает в журнал аудита действие, выполненное утилитой
+func recordAudit(ctx context.Context, a audit.Audit, action string, target string, err error) {
+	outcome := model.AuditOutcomeSuccess
+	var detail string
+	if err != nil {
+		outcome = model.AuditOutcomeFailure
+		detail = err.Error()
+	}
+	a.Record(ctx, audit.NewEvent(audit.Origin{}, action, model.AuditActorCtl, target, outcome, detail))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// gophermartctl - утилита оператора накопительной системы.
+// Чтение данных, сверка и создание администраторов работают напрямую с БД,
+// повторный опрос и смена статуса заказов - через административное API,
+// так как опрос системы начислений выполняется процессом сервиса.
+
+const usage = `usage: gophermartctl [flags] <command> [command flags] [args]
+
+commands:
+  customers list [-q query] [-limit n]    пользователи (query - часть логина или код)
+  customers show <login|code>             пользователь
+  balance <login|code>                    баланс пользователя
+  ledger <login|code>                     журнал операций баланса
+  orders requeue [-older-than d]          возобновить опрос зависших заказов (API)
+  orders set-status [-accrual n] <number> <status>
+                                          установить статус заказа (API)
+  reconcile [-repair]                     сверка балансов
+  admins create <login>                   создать администратора и выдать токен
+  admins list                             администраторы
+
+flags:
+`
+
+var errUsage = errors.New("invalid arguments")
+
+type ctl struct {
+	dsn        string
+	addr       string
+	adminToken string
+	out        *output
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := run(ctx, os.Args[1:])
+	stop()
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "gophermartctl:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	var c ctl
+	var format string
+	flags := flag.NewFlagSet("gophermartctl", flag.ContinueOnError)
+	flags.StringVar(&c.dsn, "d", os.Getenv("DATABASE_URI"), "строка подключения к БД (DATABASE_URI)")
+	flags.StringVar(&c.addr, "a", envOr("GOPHERMART_ADDRESS", "http://localhost:8080"), "адрес сервиса (GOPHERMART_ADDRESS)")
+	flags.StringVar(&c.adminToken, "t", os.Getenv("GOPHERMART_ADMIN_TOKEN"), "токен администратора (GOPHERMART_ADMIN_TOKEN)")
+	flags.StringVar(&format, "o", formatTable, "формат вывода: table или json")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	out, err := newOutput(os.Stdout, format)
+	if err != nil {
+		return err
+	}
+	c.out = out
+
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		return errUsage
+	}
+	command := args[0]
+	if len(args) > 1 {
+		switch command {
+		case "customers", "orders", "admins":
+			command += " " + args[1]
+			args = args[1:]
+		}
+	}
+	args = args[1:]
+
+	switch command {
+	case "customers list":
+		return c.customersList(ctx, args)
+	case "customers show":
+		return c.customersShow(ctx, args)
+	case "balance":
+		return c.balance(ctx, args)
+	case "ledger":
+		return c.ledger(ctx, args)
+	case "orders requeue":
+		return c.ordersRequeue(ctx, args)
+	case "orders set-status":
+		return c.ordersSetStatus(ctx, args)
+	case "reconcile":
+		return c.reconcile(ctx, args)
+	case "admins create":
+		return c.adminsCreate(ctx, args)
+	case "admins list":
+		return c.adminsList(ctx, args)
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+func envOr(name string, value string) string {
+	if env := os.Getenv(name); env != "" {
+		return env
+	}
+	return value
+}
@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/audit/config"
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/problem"
+	"github.com/iurnickita/gophermart/internal/store"
+	"go.uber.org/zap"
+)
+
+// Журнал аудита безопасности: регистрация и вход, отклоненные токены,
+// списания, корректировки баланса и действия администраторов.
+// В отличие от журнала доступа хранится в БД (таблица только на добавление)
+// и может дублироваться в файл.
+
+type Audit interface {
+	Record(ctx context.Context, event model.AuditEvent)
+	Get(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error)
+}
+
+// JSON представление записи аудита
+type EventJSON struct {
+	ID        string    `json:"id,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	IP        string    `json:"ip,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Detail    string    `json:"detail,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+func NewEventJSON(event model.AuditEvent) EventJSON {
+	return EventJSON{
+		ID:        event.ID,
+		Timestamp: event.Data.Timestamp,
+		Action:    event.Data.Action,
+		Actor:     event.Data.Actor,
+		IP:        event.Data.IP,
+		Target:    event.Data.Target,
+		Outcome:   event.Data.Outcome,
+		Detail:    event.Data.Detail,
+		RequestID: event.Data.RequestID,
+	}
+}
+
+// Максимальная длина actor и target (размер столбцов audit_log)
+const maxFieldLength = 64
+
+type audit struct {
+	store  store.Store
+	zaplog *zap.Logger
+
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func NewAudit(cfg config.Config, store store.Store, zaplog *zap.Logger) (Audit, error) {
+	a := &audit{store: store, zaplog: zaplog}
+	if cfg.File != "" {
+		file, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		a.file = file
+	}
+	return a, nil
+}
+
+// Record сохраняет запись аудита.
+// Ошибка записи не прерывает действие пользователя: запись попадает в журнал приложения
+func (a *audit) Record(ctx context.Context, event model.AuditEvent) {
+	if event.Data.Timestamp.IsZero() {
+		event.Data.Timestamp = time.Now()
+	}
+	// поля приходят в том числе от неавторизованных клиентов
+	event.Data.Actor = truncate(event.Data.Actor, maxFieldLength)
+	event.Data.Target = truncate(event.Data.Target, maxFieldLength)
+
+	// запись аудита не должна теряться из-за отмены запроса клиентом
+	err := a.store.AuditPost(context.WithoutCancel(ctx), event)
+	if err != nil {
+		a.zaplog.Error("audit record failed",
+			zap.Any("event", NewEventJSON(event)),
+			zap.Error(err))
+	}
+
+	if a.file == nil {
+		return
+	}
+	line, err := json.Marshal(NewEventJSON(event))
+	if err != nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	_, err = a.file.Write(append(line, '\n'))
+	if err != nil {
+		a.zaplog.Error("audit file write failed", zap.Error(err))
+	}
+}
+
+func (a *audit) Get(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error) {
+	return a.store.AuditGet(ctx, filter)
+}
+
+// Origin - источник действия: адрес клиента и идентификатор запроса
+type Origin struct {
+	IP        string
+	RequestID string
+}
+
+// RequestOrigin возвращает источник HTTP-запроса
+func RequestOrigin(r *http.Request) Origin {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return Origin{IP: ip, RequestID: problem.RequestID(r)}
+}
+
+// NewEvent создает запись аудита о действии из заданного источника
+func NewEvent(origin Origin, action string, actor string, target string, outcome string, detail string) model.AuditEvent {
+	return model.AuditEvent{Data: model.AuditEventData{
+		Timestamp: time.Now(),
+		Action:    action,
+		Actor:     actor,
+		IP:        origin.IP,
+		Target:    target,
+		Outcome:   outcome,
+		Detail:    detail,
+		RequestID: origin.RequestID,
+	}}
+}
+
+// RequestEvent создает запись аудита о действии в HTTP-запросе: с IP клиента и идентификатором запроса
+func RequestEvent(r *http.Request, action string, actor string, target string, outcome string, detail string) model.AuditEvent {
+	return NewEvent(RequestOrigin(r), action, actor, target, outcome, detail)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
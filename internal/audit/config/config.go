@@ -0,0 +1,6 @@
+package config
+
+type Config struct {
+	// Файл для копии журнала аудита в формате NDJSON. Пустое значение - только таблица audit_log
+	File string
+}
@@ -1,37 +1,228 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/iurnickita/gophermart/internal/audit"
+	"github.com/iurnickita/gophermart/internal/logger"
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/problem"
+	"github.com/iurnickita/gophermart/internal/referral"
 	"github.com/iurnickita/gophermart/internal/store"
 	"github.com/iurnickita/gophermart/internal/token"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type Auth interface {
 	Register(w http.ResponseWriter, r *http.Request)
 	Login(w http.ResponseWriter, r *http.Request)
 	Middleware(h http.HandlerFunc) http.HandlerFunc
+	// Регистрация и вход независимо от транспорта (HTTP, gRPC). Возвращают код пользователя
+	RegisterCustomer(ctx context.Context, origin audit.Origin, request RegisterJSONRequest) (string, error)
+	LoginCustomer(ctx context.Context, origin audit.Origin, request LoginJSONRequest) (string, error)
+	// Администраторы с персональными токенами
+	CreateAdmin(ctx context.Context, login string) (string, error)
+	AdminByToken(ctx context.Context, adminToken string) (model.AdminUser, error)
 }
 
 const (
 	UserCodeKey     = "userCode"
 	cookieUserToken = "gophermartUserToken"
+	// Количество попыток сгенерировать свободные коды пользователя
+	maxCodeAttempts = 3
+)
+
+var (
+	ErrInsufficientData   = errors.New("login and password required")
+	ErrLoginTaken         = errors.New("login already taken")
+	ErrInvalidCredentials = errors.New("invalid login or password")
 )
 
 type auth struct {
-	store store.Store
+	store    store.Store
+	referral referral.Referral
+	audit    audit.Audit
+}
+
+func NewAuth(store store.Store, referral referral.Referral, audit audit.Audit) Auth {
+	return &auth{store: store, referral: referral, audit: audit}
 }
 
-func NewAuth(store store.Store) Auth {
-	return &auth{store: store}
+type RegisterJSONRequest struct {
+	Login        string `json:"login"`
+	Password     string `json:"password"`
+	ReferralCode string `json:"referral_code,omitempty"`
 }
 
 func (a *auth) Register(w http.ResponseWriter, r *http.Request) {
+	var registerJSON RegisterJSONRequest
+	err := json.NewDecoder(r.Body).Decode(&registerJSON)
+	if err != nil {
+		problem.WriteBadRequest(w, r, err)
+		return
+	}
 
+	userCode, err := a.RegisterCustomer(r.Context(), audit.RequestOrigin(r), registerJSON)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	a.setToken(w, r, userCode)
+}
+
+func (a *auth) RegisterCustomer(ctx context.Context, origin audit.Origin, request RegisterJSONRequest) (string, error) {
+	if request.Login == "" || request.Password == "" {
+		return "", ErrInsufficientData
+	}
+
+	// реферальный код необязателен, но если указан - должен быть действительным
+	var referrer model.Customer
+	var err error
+	if request.ReferralCode != "" {
+		referrer, err = a.referral.Resolve(ctx, request.ReferralCode)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	// коды пользователя и реферальный код случайные, при совпадении с существующими генерируются заново
+	var customer model.Customer
+	for attempt := 1; ; attempt++ {
+		customer, err = newCustomer(request.Login, string(passwordHash))
+		if err != nil {
+			return "", err
+		}
+		err = a.store.CustomerPost(ctx, customer, referrer.Code)
+		if err != store.ErrCodeCollision || attempt == maxCodeAttempts {
+			break
+		}
+	}
+	if err != nil {
+		if err == store.ErrAlreadyExists {
+			a.audit.Record(ctx, audit.NewEvent(origin, model.AuditActionRegister, "", request.Login,
+				model.AuditOutcomeFailure, ErrLoginTaken.Error()))
+			return "", ErrLoginTaken
+		}
+		return "", err
+	}
+
+	a.audit.Record(ctx, audit.NewEvent(origin, model.AuditActionRegister, customer.Code, request.Login,
+		model.AuditOutcomeSuccess, ""))
+
+	return customer.Code, nil
+}
+
+type LoginJSONRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
 }
 
 func (a *auth) Login(w http.ResponseWriter, r *http.Request) {
+	var loginJSON LoginJSONRequest
+	err := json.NewDecoder(r.Body).Decode(&loginJSON)
+	if err != nil {
+		problem.WriteBadRequest(w, r, err)
+		return
+	}
+
+	userCode, err := a.LoginCustomer(r.Context(), audit.RequestOrigin(r), loginJSON)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	a.setToken(w, r, userCode)
+}
+
+func (a *auth) LoginCustomer(ctx context.Context, origin audit.Origin, request LoginJSONRequest) (string, error) {
+	if request.Login == "" || request.Password == "" {
+		return "", ErrInsufficientData
+	}
+
+	customer, err := a.store.CustomerGetByLogin(ctx, request.Login)
+	if err != nil {
+		if err == store.ErrNotFound {
+			a.audit.Record(ctx, audit.NewEvent(origin, model.AuditActionLogin, "", request.Login,
+				model.AuditOutcomeFailure, "unknown login"))
+			return "", ErrInvalidCredentials
+		}
+		return "", err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(customer.Data.PasswordHash), []byte(request.Password))
+	if err != nil {
+		a.audit.Record(ctx, audit.NewEvent(origin, model.AuditActionLogin, customer.Code, request.Login,
+			model.AuditOutcomeFailure, "wrong password"))
+		return "", ErrInvalidCredentials
+	}
+
+	a.audit.Record(ctx, audit.NewEvent(origin, model.AuditActionLogin, customer.Code, request.Login,
+		model.AuditOutcomeSuccess, ""))
+	return customer.Code, nil
+}
+
+// CreateAdmin создает администратора и возвращает его токен.
+// Токен не хранится и показывается только один раз
+func (a *auth) CreateAdmin(ctx context.Context, login string) (string, error) {
+	if login == "" {
+		return "", ErrInsufficientData
+	}
+	adminToken, tokenHash, err := token.NewAdminToken()
+	if err != nil {
+		return "", err
+	}
+	err = a.store.AdminUserPost(ctx, model.AdminUser{Login: login,
+		Data: model.AdminUserData{TokenHash: tokenHash, CreatedAt: time.Now()}})
+	if err != nil {
+		if err == store.ErrAlreadyExists {
+			return "", ErrLoginTaken
+		}
+		return "", err
+	}
+	return adminToken, nil
+}
 
+// AdminByToken возвращает администратора по его токену
+func (a *auth) AdminByToken(ctx context.Context, adminToken string) (model.AdminUser, error) {
+	admin, err := a.store.AdminUserGetByTokenHash(ctx, token.AdminTokenHash(adminToken))
+	if err != nil {
+		if err == store.ErrNotFound {
+			return model.AdminUser{}, ErrInvalidCredentials
+		}
+		return model.AdminUser{}, err
+	}
+	return admin, nil
+}
+
+// setToken выдает пользователю токен в куке и в заголовке Authorization
+func (a *auth) setToken(w http.ResponseWriter, r *http.Request, userCode string) {
+	tokenString, err := token.BuildJWTString(userCode)
+	if err != nil {
+		a.writeInternal(w, r, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieUserToken,
+		Value:    tokenString,
+		Path:     "/",
+		HttpOnly: true,
+		Expires:  time.Now().Add(token.TOKEN_EXP)})
+	w.Header().Set("Authorization", "Bearer "+tokenString)
+	w.WriteHeader(http.StatusOK)
 }
 
 func (a *auth) Middleware(h http.HandlerFunc) http.HandlerFunc {
@@ -39,12 +230,18 @@ func (a *auth) Middleware(h http.HandlerFunc) http.HandlerFunc {
 		// получение id пользователя
 		userCode, err := a.getUserCode(w, r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+			// отсутствие куки - обычный неавторизованный запрос, в аудит попадают только недействительные токены
+			if err != http.ErrNoCookie {
+				a.audit.Record(r.Context(), audit.RequestEvent(r, model.AuditActionTokenRejected, "", r.URL.Path,
+					model.AuditOutcomeDenied, err.Error()))
+			}
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "valid user token required")
 			return
 		}
 
 		// записываем
 		r.Header.Set(UserCodeKey, userCode)
+		logger.SetUser(r.Context(), userCode)
 
 		// передаём управление хендлеру
 		h.ServeHTTP(w, r)
@@ -53,15 +250,74 @@ func (a *auth) Middleware(h http.HandlerFunc) http.HandlerFunc {
 
 func (a *auth) getUserCode(_ http.ResponseWriter, r *http.Request) (string, error) {
 
-	// куки пользователя
-	var userCode string
-	tokenCookie, err := r.Cookie(cookieUserToken)
+	// токен из заголовка Authorization (его возвращают регистрация и вход) или из куки
+	tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		tokenCookie, err := r.Cookie(cookieUserToken)
+		if err != nil {
+			return "", err
+		}
+		tokenString = tokenCookie.Value
+	}
+	userCode, err := token.GetUserCode(tokenString)
 	if err != nil {
 		return "", err
 	}
-	userCode, err = token.GetUserCode(tokenCookie.Value)
+	return userCode, nil
+}
+
+// writeError отправляет ответ об ошибке регистрации или входа
+func (a *auth) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case ErrInsufficientData:
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInsufficientData, err.Error())
+	case bcrypt.ErrPasswordTooLong:
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeBadRequest, err.Error())
+	case referral.ErrInvalidCode, referral.ErrLimitReached:
+		problem.Write(w, r, http.StatusUnprocessableEntity, problem.CodeUnprocessableEntity, err.Error())
+	case ErrLoginTaken:
+		problem.Write(w, r, http.StatusConflict, problem.CodeAlreadyExists, err.Error())
+	case ErrInvalidCredentials:
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, err.Error())
+	default:
+		a.writeInternal(w, r, err)
+	}
+}
+
+// writeInternal логирует внутреннюю ошибку и отвечает без ее подробностей
+func (a *auth) writeInternal(w http.ResponseWriter, r *http.Request, err error) {
+	logger.FromContext(r.Context()).Error("auth request failed",
+		zap.String("path", r.URL.Path),
+		zap.Error(err),
+	)
+	problem.WriteInternal(w, r)
+}
+
+// newCustomer - новый пользователь со случайными кодом и реферальным кодом
+func newCustomer(login string, passwordHash string) (model.Customer, error) {
+	userCode, err := randomString(5, hex.EncodeToString)
+	if err != nil {
+		return model.Customer{}, err
+	}
+	referralCode, err := randomString(5, base32.StdEncoding.EncodeToString)
+	if err != nil {
+		return model.Customer{}, err
+	}
+	return model.Customer{
+		Code: userCode,
+		Data: model.CustomerData{
+			Login:        login,
+			PasswordHash: passwordHash,
+			ReferralCode: referralCode,
+			CreatedAt:    time.Now()}}, nil
+}
+
+// randomString - случайная строка из n байт в заданной кодировке
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
 	if err != nil {
 		return "", err
 	}
-	return userCode, nil
+	return encode(b), nil
 }
@@ -2,17 +2,23 @@ package balance
 
 import (
 	"context"
+	"time"
 
 	"github.com/iurnickita/gophermart/internal/model"
 	"github.com/iurnickita/gophermart/internal/store"
 )
 
 type Balance interface {
-	Increase(customer string, order string, points int) error
-	Decrease(customer string, order string, points int) error
-	Get(customer string) (model.Balance, error)
-	GetWithdrawals(customer string) ([]model.Balance, error)
-	GetHistory(customer string) ([]model.Balance, error)
+	Increase(ctx context.Context, customer string, order string, points int) error
+	Decrease(ctx context.Context, customer string, order string, points int) error
+	Reverse(ctx context.Context, customer string, operation string) (model.Balance, error)
+	Authorize(ctx context.Context, customer string, order string, points int, ttl time.Duration) (model.BalanceHold, error)
+	Capture(ctx context.Context, hold string) (model.Balance, error)
+	Release(ctx context.Context, hold string) error
+	ExpireHolds(ctx context.Context) (int, error)
+	Get(ctx context.Context, customer string) (model.Balance, error)
+	GetWithdrawals(ctx context.Context, customer string) ([]model.Balance, error)
+	GetHistory(ctx context.Context, customer string) ([]model.Balance, error)
 }
 
 type balance struct {
@@ -24,32 +30,62 @@ func NewBalance(store store.Store) Balance {
 	return &balance
 }
 
-func (balance *balance) Get(customer string) (model.Balance, error) {
-	ctx := context.Background()
+func (balance *balance) Get(ctx context.Context, customer string) (model.Balance, error) {
+	balanceRow, err := balance.store.BalanceGetActual(ctx, customer)
+	if err != nil {
+		return model.Balance{}, err
+	}
+	balanceRow.Data.OnHold, err = balance.store.BalanceGetOnHold(ctx, customer)
+	if err != nil {
+		return model.Balance{}, err
+	}
+	return balanceRow, nil
+}
 
-	return balance.store.BalanceGetActual(ctx, customer)
+func (balance *balance) GetWithdrawals(ctx context.Context, customer string) ([]model.Balance, error) {
+	return balance.store.BalanceGetWithdrawals(ctx, customer)
 }
 
-func (balance *balance) GetWithdrawals(customer string) ([]model.Balance, error) {
-	ctx := context.Background()
+func (balance *balance) GetHistory(ctx context.Context, customer string) ([]model.Balance, error) {
+	return balance.store.BalanceGetHistory(ctx, customer)
+}
 
-	return balance.store.BalanceGetWithdrawals(ctx, customer)
+func (balance *balance) Increase(ctx context.Context, customer string, order string, points int) error {
+	return balance.store.BalanceIncrease(ctx, customer, order, points)
 }
 
-func (balance *balance) GetHistory(customer string) ([]model.Balance, error) {
-	ctx := context.Background()
+func (balance *balance) Decrease(ctx context.Context, customer string, order string, points int) error {
+	return balance.store.BalanceDecrease(ctx, customer, order, points)
+}
 
-	return balance.store.BalanceGetHistory(ctx, customer)
+func (balance *balance) Reverse(ctx context.Context, customer string, operation string) (model.Balance, error) {
+	return balance.store.BalanceReverse(ctx, customer, operation)
 }
 
-func (balance *balance) Increase(customer string, order string, points int) error {
-	ctx := context.Background()
+// Authorize резервирует баллы на время ttl. Зарезервированные баллы недоступны для списания
+func (balance *balance) Authorize(ctx context.Context, customer string, order string, points int, ttl time.Duration) (model.BalanceHold, error) {
+	now := time.Now()
+	hold := model.BalanceHold{
+		Data: model.BalanceHoldData{
+			Customer:  customer,
+			Order:     order,
+			Points:    points,
+			CreatedAt: now,
+			ExpiresAt: now.Add(ttl)}}
+	return balance.store.BalanceHoldPost(ctx, hold)
+}
 
-	return balance.store.BalanceIncrease(ctx, customer, order, points)
+// Capture превращает холд в списание
+func (balance *balance) Capture(ctx context.Context, hold string) (model.Balance, error) {
+	return balance.store.BalanceHoldCapture(ctx, hold)
 }
 
-func (balance *balance) Decrease(customer string, order string, points int) error {
-	ctx := context.Background()
+// Release возвращает зарезервированные баллы
+func (balance *balance) Release(ctx context.Context, hold string) error {
+	return balance.store.BalanceHoldRelease(ctx, hold)
+}
 
-	return balance.store.BalanceDecrease(ctx, customer, order, points)
+// ExpireHolds помечает истекшие холды
+func (balance *balance) ExpireHolds(ctx context.Context) (int, error) {
+	return balance.store.BalanceHoldExpire(ctx)
 }
@@ -0,0 +1,359 @@
+package compress
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/iurnickita/gophermart/internal/compress/config"
+	"github.com/iurnickita/gophermart/internal/problem"
+)
+
+// Размер ответа по умолчанию, начиная с которого он сжимается.
+// Меньшие ответы не окупают заголовки и накладные расходы сжатия
+const defaultMinSize = 1024
+
+var defaultEncodings = []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+
+// Лимиты тела запроса по умолчанию. Запросы API - небольшие JSON, CSV пакета заказов
+// на 1000 номеров занимает около 12 КБ
+const (
+	defaultMaxRequestSize      = 1 << 20
+	defaultMaxDecompressedSize = 4 << 20
+	defaultMaxRatio            = 100
+	// Ниже этого размера степень сжатия не проверяется: короткие тела из повторов
+	// легитимно сжимаются сильнее MaxRatio
+	ratioCheckMinSize = 64 << 10
+)
+
+type Compressor interface {
+	Middleware(h http.HandlerFunc) http.HandlerFunc
+}
+
+type compressor struct {
+	minSize   int
+	encodings []string
+	codecs    map[string]*codec
+
+	maxRequestSize      int64
+	maxDecompressedSize int64
+	maxRatio            int64
+}
+
+func NewCompressor(cfg config.Config) Compressor {
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = defaultMinSize
+	}
+	if cfg.MaxRequestSize <= 0 {
+		cfg.MaxRequestSize = defaultMaxRequestSize
+	}
+	if cfg.MaxDecompressedSize <= 0 {
+		cfg.MaxDecompressedSize = defaultMaxDecompressedSize
+	}
+	if cfg.MaxRatio <= 0 {
+		cfg.MaxRatio = defaultMaxRatio
+	}
+	codecs := newCodecs()
+	var encodings []string
+	for _, encoding := range cfg.Encodings {
+		if _, ok := codecs[encoding]; ok {
+			encodings = append(encodings, encoding)
+		}
+	}
+	if len(encodings) == 0 {
+		encodings = defaultEncodings
+	}
+	return &compressor{
+		minSize:             cfg.MinSize,
+		encodings:           encodings,
+		codecs:              codecs,
+		maxRequestSize:      cfg.MaxRequestSize,
+		maxDecompressedSize: cfg.MaxDecompressedSize,
+		maxRatio:            cfg.MaxRatio,
+	}
+}
+
+// Middleware распаковывает тело запроса по Content-Encoding
+// и сжимает ответ кодировкой, выбранной по Accept-Encoding.
+// Распаковываются все поддерживаемые кодировки, независимо от настройки Encodings.
+// Размер тела ограничивается до и после распаковки: чтение сверх лимита
+// возвращает *http.MaxBytesError, обработчик отвечает 413
+func (c *compressor) Middleware(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// ответ зависит от Accept-Encoding, это нужно учитывать кэшам
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		// тело запроса
+		if r.ContentLength > c.maxRequestSize {
+			problem.WriteBadRequest(w, r, &http.MaxBytesError{Limit: c.maxRequestSize})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, c.maxRequestSize)
+		contentEncoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		switch contentEncoding {
+		case "", EncodingIdentity:
+		default:
+			if contentEncoding == "x-gzip" {
+				contentEncoding = EncodingGzip
+			}
+			codec, ok := c.codecs[contentEncoding]
+			if !ok {
+				problem.Write(w, r, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType,
+					"unsupported request content encoding: "+contentEncoding)
+				return
+			}
+			cr, err := newCompressReader(codec, r.Body, c.maxDecompressedSize, c.maxRatio)
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					problem.WriteBadRequest(w, r, err)
+					return
+				}
+				problem.Write(w, r, http.StatusBadRequest, problem.CodeBadRequest,
+					"invalid "+contentEncoding+" request body")
+				return
+			}
+			defer cr.Close()
+			r.Body = cr
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+
+		// ответ
+		encoding := negotiate(r.Header.Get("Accept-Encoding"), c.encodings)
+		if encoding == "" || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := newCompressWriter(w, c.codecs[encoding], encoding, c.minSize)
+		// не забываем отправить клиенту все данные после завершения обработчика
+		defer cw.Close()
+
+		h.ServeHTTP(cw, r)
+	}
+}
+
+// compressWriter реализует интерфейс http.ResponseWriter и позволяет прозрачно для сервера
+// сжимать передаваемые данные и выставлять правильные HTTP-заголовки.
+// Решение о сжатии откладывается, пока не накопится minSize байт или не завершится ответ:
+// маленькие ответы, ответы с ошибками и несжимаемые типы содержимого передаются как есть
+type compressWriter struct {
+	w        http.ResponseWriter
+	codec    *codec
+	encoding string
+	minSize  int
+
+	statusCode int
+	buf        []byte
+	// decided - заголовки отправлены, дальше данные пишутся в enc или напрямую в w
+	decided bool
+	enc     encoder
+}
+
+func newCompressWriter(w http.ResponseWriter, codec *codec, encoding string, minSize int) *compressWriter {
+	return &compressWriter{
+		w:        w,
+		codec:    codec,
+		encoding: encoding,
+		minSize:  minSize,
+	}
+}
+
+func (c *compressWriter) Header() http.Header {
+	return c.w.Header()
+}
+
+func (c *compressWriter) WriteHeader(statusCode int) {
+	if c.decided || c.statusCode != 0 {
+		return
+	}
+	// информационные ответы отправляются сразу и не завершают ответ
+	if statusCode >= 100 && statusCode < 200 {
+		c.w.WriteHeader(statusCode)
+		return
+	}
+	c.statusCode = statusCode
+	if !compressibleStatus(statusCode) {
+		c.decide(false)
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.statusCode == 0 {
+		c.statusCode = http.StatusOK
+	}
+	if !c.decided {
+		c.buf = append(c.buf, p...)
+		if len(c.buf) < c.minSize {
+			return len(p), nil
+		}
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if c.enc != nil {
+		return c.enc.Write(p)
+	}
+	return c.w.Write(p)
+}
+
+// Flush отправляет накопленные данные клиенту
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		if c.statusCode == 0 {
+			c.statusCode = http.StatusOK
+		}
+		c.decide(len(c.buf) >= c.minSize)
+	}
+	if c.enc != nil {
+		c.enc.Flush()
+	}
+	http.NewResponseController(c.w).Flush()
+}
+
+func (c *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(c.w).Hijack()
+}
+
+// Unwrap позволяет http.ResponseController добраться до исходного http.ResponseWriter
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
+// Close отправляет недосланные данные и возвращает компрессор в пул
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		if c.statusCode == 0 {
+			// обработчик ничего не записал
+			return nil
+		}
+		return c.decide(len(c.buf) >= c.minSize)
+	}
+	if c.enc == nil {
+		return nil
+	}
+	err := c.enc.Close()
+	c.codec.putEncoder(c.enc)
+	c.enc = nil
+	return err
+}
+
+// decide отправляет заголовки и накопленные данные, сжимая их, если это возможно
+func (c *compressWriter) decide(compress bool) error {
+	c.decided = true
+	header := c.w.Header()
+	if compress {
+		if header.Get("Content-Type") == "" {
+			// то же определение типа, что выполнил бы net/http
+			header.Set("Content-Type", http.DetectContentType(c.buf))
+		}
+		compress = compressibleStatus(c.statusCode) &&
+			header.Get("Content-Encoding") == "" &&
+			compressibleContentType(header.Get("Content-Type"))
+	}
+
+	if compress {
+		header.Set("Content-Encoding", c.encoding)
+		header.Del("Content-Length")
+		c.enc = c.codec.getEncoder(c.w)
+	}
+	c.w.WriteHeader(c.statusCode)
+
+	buf := c.buf
+	c.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.enc != nil {
+		_, err = c.enc.Write(buf)
+	} else {
+		_, err = c.w.Write(buf)
+	}
+	return err
+}
+
+// Сжимаются только успешные ответы с телом
+func compressibleStatus(statusCode int) bool {
+	return statusCode >= 200 && statusCode < 300 &&
+		statusCode != http.StatusNoContent &&
+		statusCode != http.StatusPartialContent
+}
+
+// compressReader реализует интерфейс io.ReadCloser и позволяет прозрачно для сервера
+// декомпрессировать получаемые от клиента данные.
+// Защищает от "zip-бомб": ограничивает распакованный размер и степень сжатия
+type compressReader struct {
+	r     io.ReadCloser
+	codec *codec
+	dec   decoder
+
+	compressed   *countingReader
+	decompressed int64
+	maxSize      int64
+	maxRatio     int64
+}
+
+func newCompressReader(codec *codec, r io.ReadCloser, maxSize int64, maxRatio int64) (*compressReader, error) {
+	compressed := &countingReader{r: r}
+	dec, err := codec.getDecoder(compressed)
+	if err != nil {
+		return nil, err
+	}
+	return &compressReader{
+		r:          r,
+		codec:      codec,
+		dec:        dec,
+		compressed: compressed,
+		maxSize:    maxSize,
+		maxRatio:   maxRatio,
+	}, nil
+}
+
+func (c *compressReader) Read(p []byte) (n int, err error) {
+	if c.dec == nil {
+		return 0, errors.New("read from closed body")
+	}
+	if c.decompressed > c.maxSize {
+		return 0, &http.MaxBytesError{Limit: c.maxSize}
+	}
+	// читаем не больше, чем осталось до лимита, плюс байт для обнаружения превышения
+	if remaining := c.maxSize - c.decompressed + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err = c.dec.Read(p)
+	c.decompressed += int64(n)
+	if c.decompressed > c.maxSize {
+		return 0, &http.MaxBytesError{Limit: c.maxSize}
+	}
+	if c.decompressed > ratioCheckMinSize && c.decompressed > c.compressed.n*c.maxRatio {
+		return 0, &http.MaxBytesError{Limit: c.compressed.n * c.maxRatio}
+	}
+	return n, err
+}
+
+// Close закрывает тело запроса и возвращает декомпрессор в пул
+func (c *compressReader) Close() error {
+	if c.dec != nil {
+		c.codec.putDecoder(c.dec)
+		c.dec = nil
+	}
+	return c.r.Close()
+}
+
+// countingReader считает прочитанные сжатые байты
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,17 @@
+package config
+
+type Config struct {
+	// Минимальный размер ответа для сжатия, байт. Меньшие ответы отправляются как есть
+	MinSize int
+	// Поддерживаемые кодировки ответа в порядке предпочтения сервера: "zstd", "br", "gzip".
+	// Пустое значение - все
+	Encodings []string
+
+	// Лимиты тела запроса. Превышение - ответ 413
+	// Максимальный размер тела в том виде, в котором оно передано (сжатом или нет), байт
+	MaxRequestSize int64
+	// Максимальный размер распакованного тела, байт
+	MaxDecompressedSize int64
+	// Максимальная степень сжатия (распакованный размер / сжатый)
+	MaxRatio int64
+}
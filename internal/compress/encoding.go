@@ -0,0 +1,172 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Кодировки содержимого
+const (
+	EncodingZstd     = "zstd"
+	EncodingBrotli   = "br"
+	EncodingGzip     = "gzip"
+	EncodingIdentity = "identity"
+)
+
+// encoder - потоковый компрессор, переиспользуемый через Reset
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// decoder - потоковый декомпрессор, переиспользуемый через Reset
+type decoder interface {
+	io.Reader
+	Reset(r io.Reader) error
+}
+
+// codec хранит пулы компрессоров и декомпрессоров одной кодировки.
+// Создание компрессора дорогое (особенно zstd и brotli), поэтому они не создаются на каждый запрос
+type codec struct {
+	encoders sync.Pool
+	decoders sync.Pool
+	// newDecoder создает декомпрессор, читающий r
+	newDecoder func(r io.Reader) (decoder, error)
+}
+
+func newCodecs() map[string]*codec {
+	return map[string]*codec{
+		EncodingGzip: {
+			encoders: sync.Pool{New: func() any {
+				return gzip.NewWriter(io.Discard)
+			}},
+			newDecoder: func(r io.Reader) (decoder, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		EncodingBrotli: {
+			encoders: sync.Pool{New: func() any {
+				// для динамических ответов уровень по умолчанию (6) слишком медленный
+				return brotli.NewWriterLevel(io.Discard, 4)
+			}},
+			newDecoder: func(r io.Reader) (decoder, error) {
+				return brotli.NewReader(r), nil
+			},
+		},
+		EncodingZstd: {
+			encoders: sync.Pool{New: func() any {
+				zw, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+				return zw
+			}},
+			newDecoder: func(r io.Reader) (decoder, error) {
+				return zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+			},
+		},
+	}
+}
+
+func (c *codec) getEncoder(w io.Writer) encoder {
+	enc := c.encoders.Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+func (c *codec) putEncoder(enc encoder) {
+	enc.Reset(io.Discard)
+	c.encoders.Put(enc)
+}
+
+func (c *codec) getDecoder(r io.Reader) (decoder, error) {
+	if dec, ok := c.decoders.Get().(decoder); ok {
+		if err := dec.Reset(r); err != nil {
+			c.decoders.Put(dec)
+			return nil, err
+		}
+		return dec, nil
+	}
+	return c.newDecoder(r)
+}
+
+func (c *codec) putDecoder(dec decoder) {
+	c.decoders.Put(dec)
+}
+
+// negotiate выбирает кодировку ответа по заголовку Accept-Encoding (RFC 9110, 12.5.3).
+// Выбирается кодировка с наибольшим q, при равных q - первая в списке предпочтений сервера.
+// Пустая строка - ответ не сжимается
+func negotiate(acceptEncoding string, preferred []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qvalues := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
+			}
+			q = parsed
+		}
+		switch name {
+		case "*":
+			wildcard = q
+		case "x-gzip":
+			qvalues[EncodingGzip] = q
+		default:
+			qvalues[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range preferred {
+		q, ok := qvalues[encoding]
+		if !ok {
+			q = max(wildcard, 0)
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressibleContentType сообщает, имеет ли смысл сжимать содержимое этого типа.
+// Изображения, архивы и прочие уже сжатые форматы не сжимаются
+func compressibleContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json",
+		"application/x-ndjson",
+		"application/xml",
+		"application/javascript",
+		"application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package compress
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	preferred := []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		want           string
+	}{
+		{name: "empty", acceptEncoding: "", want: ""},
+		{name: "single", acceptEncoding: "gzip", want: EncodingGzip},
+		{name: "server preference on equal q", acceptEncoding: "gzip, br, zstd", want: EncodingZstd},
+		{name: "highest q", acceptEncoding: "zstd;q=0.5, br;q=0.8, gzip;q=0.9", want: EncodingGzip},
+		{name: "case and spaces", acceptEncoding: " GZIP ; Q=0.5 , Br;q=0.4", want: EncodingGzip},
+		{name: "x-gzip", acceptEncoding: "x-gzip", want: EncodingGzip},
+		{name: "unsupported", acceptEncoding: "deflate, compress", want: ""},
+		{name: "identity only", acceptEncoding: "identity", want: ""},
+		{name: "zero q", acceptEncoding: "gzip;q=0", want: ""},
+		{name: "wildcard", acceptEncoding: "*", want: EncodingZstd},
+		{name: "wildcard with exclusion", acceptEncoding: "*;q=0.5, zstd;q=0", want: EncodingBrotli},
+		{name: "explicit over wildcard", acceptEncoding: "*;q=0.1, gzip", want: EncodingGzip},
+		{name: "wildcard disabled", acceptEncoding: "*;q=0", want: ""},
+		{name: "invalid q", acceptEncoding: "br;q=abc, gzip;q=2, zstd;q=-1", want: ""},
+		{name: "other params", acceptEncoding: "gzip;level=9", want: EncodingGzip},
+		{name: "empty elements", acceptEncoding: ",, gzip,", want: EncodingGzip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiate(tt.acceptEncoding, preferred); got != tt.want {
+				t.Errorf("negotiate(%q) = %q, want %q", tt.acceptEncoding, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,19 +1,53 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+
+	auditConfig "github.com/iurnickita/gophermart/internal/audit/config"
+	grpcConfig "github.com/iurnickita/gophermart/internal/grpcserver/config"
 	handlerConfig "github.com/iurnickita/gophermart/internal/handler/config"
 	loggerConfig "github.com/iurnickita/gophermart/internal/logger/config"
+	outboxConfig "github.com/iurnickita/gophermart/internal/outbox/config"
+	reconciliationConfig "github.com/iurnickita/gophermart/internal/reconciliation/config"
+	referralConfig "github.com/iurnickita/gophermart/internal/referral/config"
 	serviceConfig "github.com/iurnickita/gophermart/internal/service/config"
 	storeConfig "github.com/iurnickita/gophermart/internal/store/config"
+	tracingConfig "github.com/iurnickita/gophermart/internal/tracing/config"
+	webhookConfig "github.com/iurnickita/gophermart/internal/webhook/config"
 )
 
 type Config struct {
 	Handler handlerConfig.Config
+	GRPC    grpcConfig.Config
 	Service serviceConfig.Config
 	Store   storeConfig.Config
 	Logger  loggerConfig.Config
+
+	Reconciliation reconciliationConfig.Config
+	Outbox         outboxConfig.Config
+	Referral       referralConfig.Config
+	Webhook        webhookConfig.Config
+	Tracing        tracingConfig.Config
+	Audit          auditConfig.Config
 }
 
 func GetConfig() Config {
 	return Config{}
 }
+
+// Validate проверяет согласованность настроек разных компонентов
+func (cfg Config) Validate() error {
+	switch cfg.Service.AccrualMode {
+	case "", serviceConfig.AccrualModePoll, serviceConfig.AccrualModeHybrid:
+	case serviceConfig.AccrualModePush:
+		// без секрета прием уведомлений отключен, а опрос в push-режиме не выполняется:
+		// заказы никогда не будут обработаны
+		if cfg.Handler.AccrualCallbackSecret == "" {
+			return errors.New("accrual mode push requires accrual callback secret")
+		}
+	default:
+		return fmt.Errorf("unknown accrual mode %q", cfg.Service.AccrualMode)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package config
+
+import "time"
+
+type Config struct {
+	// Адрес gRPC-сервера. Пустое значение - gRPC API отключено
+	Addr string
+	// TLS. Пустой TLSCertFile - сервер принимает соединения без шифрования
+	TLSCertFile string
+	TLSKeyFile  string
+	// Регистрация сервиса reflection для grpcurl и подобных клиентов
+	Reflection bool
+	// Время на завершение начатых вызовов при остановке сервера
+	ShutdownTimeout time.Duration
+}
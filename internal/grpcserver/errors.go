@@ -0,0 +1,42 @@
+package grpcserver
+
+import (
+	"context"
+
+	"github.com/iurnickita/gophermart/internal/auth"
+	"github.com/iurnickita/gophermart/internal/logger"
+	"github.com/iurnickita/gophermart/internal/referral"
+	"github.com/iurnickita/gophermart/internal/service"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// knownErrors - соответствие ошибок сервиса и авторизации кодам gRPC
+var knownErrors = map[error]codes.Code{
+	service.ErrInsufficientData:    codes.InvalidArgument,
+	service.ErrUnprocessableEntity: codes.InvalidArgument,
+	service.ErrAlreadyExists:       codes.AlreadyExists,
+	service.ErrDuplicateRequest:    codes.AlreadyExists,
+	service.ErrInsufficientFunds:   codes.FailedPrecondition,
+	service.ErrNotFound:            codes.NotFound,
+	service.ErrConflict:            codes.Aborted,
+
+	auth.ErrInsufficientData:   codes.InvalidArgument,
+	auth.ErrLoginTaken:         codes.AlreadyExists,
+	auth.ErrInvalidCredentials: codes.Unauthenticated,
+	bcrypt.ErrPasswordTooLong:  codes.InvalidArgument,
+	referral.ErrInvalidCode:    codes.FailedPrecondition,
+	referral.ErrLimitReached:   codes.FailedPrecondition,
+}
+
+// toStatus преобразует ошибку в статус gRPC.
+// Неизвестные ошибки логируются и отдаются клиенту как внутренняя ошибка без подробностей
+func toStatus(ctx context.Context, err error) error {
+	if code, ok := knownErrors[err]; ok {
+		return status.Error(code, err.Error())
+	}
+	logger.FromContext(ctx).Error("grpc call failed", zap.Error(err))
+	return status.Error(codes.Internal, "internal error")
+}
@@ -0,0 +1,166 @@
+package grpcserver
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/iurnickita/gophermart/internal/audit"
+	"github.com/iurnickita/gophermart/internal/auth"
+	"github.com/iurnickita/gophermart/internal/grpcserver/pb"
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/pubsub"
+	"github.com/iurnickita/gophermart/internal/service"
+	"github.com/iurnickita/gophermart/internal/token"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (s *server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.AuthResponse, error) {
+	userCode, err := s.auth.RegisterCustomer(ctx, origin(ctx), auth.RegisterJSONRequest{
+		Login:        req.GetLogin(),
+		Password:     req.GetPassword(),
+		ReferralCode: req.GetReferralCode()})
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return s.authResponse(ctx, userCode)
+}
+
+func (s *server) Login(ctx context.Context, req *pb.LoginRequest) (*pb.AuthResponse, error) {
+	userCode, err := s.auth.LoginCustomer(ctx, origin(ctx), auth.LoginJSONRequest{
+		Login:    req.GetLogin(),
+		Password: req.GetPassword()})
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return s.authResponse(ctx, userCode)
+}
+
+func (s *server) authResponse(ctx context.Context, userCode string) (*pb.AuthResponse, error) {
+	tokenString, err := token.BuildJWTString(userCode)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &pb.AuthResponse{Token: tokenString}, nil
+}
+
+func (s *server) UploadOrder(ctx context.Context, req *pb.UploadOrderRequest) (*pb.UploadOrderResponse, error) {
+	order := model.PurchaseOrder{Number: req.GetNumber(),
+		Data: model.PurchaseOrderData{Customer: userCode(ctx)}}
+	err := s.service.PostOrder(ctx, order)
+	if err != nil {
+		// повторная загрузка своего заказа - не ошибка
+		if err == service.ErrDuplicateRequest {
+			return &pb.UploadOrderResponse{AlreadyUploaded: true}, nil
+		}
+		return nil, toStatus(ctx, err)
+	}
+	return &pb.UploadOrderResponse{}, nil
+}
+
+func (s *server) ListOrders(ctx context.Context, _ *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
+	orders, err := s.service.GetOrder(ctx, userCode(ctx))
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	var response pb.ListOrdersResponse
+	for _, order := range orders {
+		response.Orders = append(response.Orders, orderToPB(order))
+	}
+	return &response, nil
+}
+
+// WatchOrders передает изменения статусов и начислений заказов пользователя,
+// как GET /api/user/orders/stream
+func (s *server) WatchOrders(req *pb.WatchOrdersRequest, stream grpc.ServerStreamingServer[pb.OrderEvent]) error {
+	ctx := stream.Context()
+	backlog, events, cancel, err := s.service.SubscribeOrders(ctx, userCode(ctx), req.GetLastEventId())
+	if err != nil {
+		return toStatus(ctx, err)
+	}
+	defer cancel()
+
+	for _, event := range backlog {
+		if err := stream.Send(orderEventToPB(event)); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.shutdown:
+			// клиент переподключится к другому экземпляру с last_event_id
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				// подписка закрыта брокером - клиент переподключится с last_event_id
+				return nil
+			}
+			if err := stream.Send(orderEventToPB(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *server) GetBalance(ctx context.Context, _ *pb.GetBalanceRequest) (*pb.GetBalanceResponse, error) {
+	balance, err := s.service.GetBalance(ctx, userCode(ctx))
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &pb.GetBalanceResponse{
+		Current:   int64(balance.Data.Balance - balance.Data.OnHold),
+		Withdrawn: int64(balance.Data.Withdrawn),
+		OnHold:    int64(balance.Data.OnHold)}, nil
+}
+
+func (s *server) Withdraw(ctx context.Context, req *pb.WithdrawRequest) (*pb.WithdrawResponse, error) {
+	customer := userCode(ctx)
+	order := model.PurchaseOrder{
+		Number: req.GetOrder(),
+		Data:   model.PurchaseOrderData{Customer: customer}}
+	err := s.service.PostWithdraw(ctx, order, int(req.GetSum()))
+
+	outcome := model.AuditOutcomeSuccess
+	detail := "sum " + strconv.FormatInt(req.GetSum(), 10)
+	if err != nil {
+		outcome = model.AuditOutcomeFailure
+		detail += ": " + err.Error()
+	}
+	s.audit.Record(ctx, audit.NewEvent(origin(ctx), model.AuditActionWithdraw, customer, req.GetOrder(), outcome, detail))
+
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &pb.WithdrawResponse{}, nil
+}
+
+func (s *server) ListWithdrawals(ctx context.Context, _ *pb.ListWithdrawalsRequest) (*pb.ListWithdrawalsResponse, error) {
+	withdrawals, err := s.service.GetWithdrawals(ctx, userCode(ctx))
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	var response pb.ListWithdrawalsResponse
+	for _, withdraw := range withdrawals {
+		response.Withdrawals = append(response.Withdrawals, &pb.Withdrawal{
+			Operation:   withdraw.Key.Operation,
+			Order:       withdraw.Data.Order,
+			Sum:         int64(-withdraw.Data.Difference),
+			ProcessedAt: timestamppb.New(withdraw.Data.Timestamp),
+			Reversed:    withdraw.Data.Reversed})
+	}
+	return &response, nil
+}
+
+func orderToPB(order model.PurchaseOrder) *pb.Order {
+	return &pb.Order{
+		Number:     order.Number,
+		Status:     order.Data.Status,
+		Accrual:    int64(order.Data.Accrual),
+		UploadedAt: timestamppb.New(order.Data.UploadedAt)}
+}
+
+func orderEventToPB(event pubsub.OrderEvent) *pb.OrderEvent {
+	return &pb.OrderEvent{Id: event.ID, Order: orderToPB(event.Order)}
+}
@@ -0,0 +1,112 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/audit"
+	"github.com/iurnickita/gophermart/internal/auth"
+	"github.com/iurnickita/gophermart/internal/grpcserver/config"
+	"github.com/iurnickita/gophermart/internal/grpcserver/pb"
+	"github.com/iurnickita/gophermart/internal/service"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
+)
+
+// gRPC API повторяет пользовательское HTTP API поверх того же service.Service.
+// Токен передается в метаданных authorization: Bearer <token>
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// Serve запускает gRPC-сервер и останавливает его после отмены ctx,
+// давая начатым вызовам ShutdownTimeout на завершение
+func Serve(ctx context.Context, cfg config.Config, auth auth.Auth, service service.Service, audit audit.Audit, zaplog *zap.Logger) error {
+	if cfg.Addr == "" {
+		return nil
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+
+	opts := []grpc.ServerOption{
+		// span на каждый вызов, трасса продолжается из метаданных traceparent
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	}
+	if cfg.TLSCertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+
+	s := newServer(ctx.Done(), auth, service, audit, zaplog)
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(s.logUnary, s.authUnary),
+		grpc.ChainStreamInterceptor(s.logStream, s.authStream))
+
+	srv := grpc.NewServer(opts...)
+	pb.RegisterGophermartServer(srv, s)
+	if cfg.Reflection {
+		reflection.Register(srv)
+	}
+
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		// GracefulStop не отменяет контексты вызовов: потоки WatchOrders завершаются сами
+		// по server.shutdown, а зависшие вызовы обрывает Stop по таймауту
+		graceful := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(graceful)
+		}()
+		select {
+		case <-graceful:
+		case <-time.After(cfg.ShutdownTimeout):
+			srv.Stop()
+			<-graceful
+		}
+	}()
+
+	// после GracefulStop или Stop Serve возвращает nil, не дожидаясь начатых вызовов -
+	// их завершения ждет горутина остановки
+	err = srv.Serve(listener)
+	if err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	<-stopped
+	return nil
+}
+
+type server struct {
+	pb.UnimplementedGophermartServer
+
+	// закрывается при остановке сервера, чтобы завершить потоки WatchOrders
+	shutdown <-chan struct{}
+	auth     auth.Auth
+	service  service.Service
+	audit    audit.Audit
+	zaplog   *zap.Logger
+}
+
+func newServer(shutdown <-chan struct{}, auth auth.Auth, service service.Service, audit audit.Audit, zaplog *zap.Logger) *server {
+	return &server{
+		shutdown: shutdown,
+		auth:     auth,
+		service:  service,
+		audit:    audit,
+		zaplog:   zaplog,
+	}
+}
@@ -0,0 +1,171 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/audit"
+	"github.com/iurnickita/gophermart/internal/grpcserver/pb"
+	"github.com/iurnickita/gophermart/internal/logger"
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/problem"
+	"github.com/iurnickita/gophermart/internal/token"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Методы, доступные без токена
+var publicMethods = map[string]bool{
+	pb.Gophermart_Register_FullMethodName: true,
+	pb.Gophermart_Login_FullMethodName:    true,
+}
+
+var errNoToken = errors.New("no token")
+
+type userCodeKey struct{}
+
+// userCode возвращает код пользователя, проверенный authUnary или authStream
+func userCode(ctx context.Context) string {
+	userCode, _ := ctx.Value(userCodeKey{}).(string)
+	return userCode
+}
+
+// origin возвращает источник вызова для журнала аудита
+func origin(ctx context.Context) audit.Origin {
+	var origin audit.Origin
+	if p, ok := peer.FromContext(ctx); ok {
+		ip, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			ip = p.Addr.String()
+		}
+		origin.IP = ip
+	}
+	origin.RequestID = requestID(ctx)
+	return origin
+}
+
+// requestID возвращает идентификатор из метаданных x-request-id,
+// если он допустим (см. logger.ValidRequestID)
+func requestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(strings.ToLower(problem.RequestIDHeader))
+	if len(values) == 0 || !logger.ValidRequestID(values[0]) {
+		return ""
+	}
+	return values[0]
+}
+
+// authenticate проверяет токен из метаданных и кладет код пользователя в контекст
+func (s *server) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if publicMethods[method] {
+		return ctx, nil
+	}
+	userCode, err := bearerUserCode(ctx)
+	if err != nil {
+		// отсутствие токена - обычный неавторизованный вызов, в аудит попадают только недействительные токены
+		if err != errNoToken {
+			s.audit.Record(ctx, audit.NewEvent(origin(ctx), model.AuditActionTokenRejected, "", method,
+				model.AuditOutcomeDenied, err.Error()))
+		}
+		return nil, status.Error(codes.Unauthenticated, "valid user token required")
+	}
+	logger.SetUser(ctx, userCode)
+	return context.WithValue(ctx, userCodeKey{}, userCode), nil
+}
+
+func bearerUserCode(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", errNoToken
+	}
+	tokenString, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok {
+		return "", token.ErrInvalidToken
+	}
+	return token.GetUserCode(tokenString)
+}
+
+func (s *server) authUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *server) authStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// newCallLog кладет в контекст логер вызова с полями request_id и trace_id,
+// как RequestLogMdlw для HTTP-запросов
+func (s *server) newCallLog(ctx context.Context) context.Context {
+	var fields []zap.Field
+	if requestID := requestID(ctx); requestID != "" {
+		fields = append(fields, zap.String("request_id", requestID))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		fields = append(fields, zap.String("trace_id", spanContext.TraceID().String()))
+	}
+	return logger.NewContext(ctx, s.zaplog.With(fields...))
+}
+
+// logCall пишет одну запись журнала доступа по завершении вызова
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := zapcore.InfoLevel
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		level = zapcore.ErrorLevel
+	}
+	fields := []zap.Field{
+		zap.String("method", method),
+		zap.String("code", code.String()),
+		zap.Duration("duration", time.Since(start)),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields = append(fields, zap.String("remote_addr", p.Addr.String()))
+	}
+	// user добавляется в логер вызова при авторизации (SetUser)
+	logger.FromContext(ctx).Log(level, "grpc request", fields...)
+}
+
+func (s *server) logUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx = s.newCallLog(ctx)
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (s *server) logStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := s.newCallLog(ss.Context())
+	start := time.Now()
+	err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	logCall(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// contextStream подменяет контекст потока
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
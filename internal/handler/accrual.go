@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/iurnickita/gophermart/internal/problem"
+	"github.com/iurnickita/gophermart/internal/service/accrualclient"
+)
+
+// Заголовок с подписью тела push-уведомления: "sha256=<hex HMAC-SHA256>"
+const accrualSignatureHeader = "X-Accrual-Signature"
+
+// accrualSignatureMiddleware пропускает запрос, только если тело подписано общим секретом
+func (h *handler) accrualSignatureMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			h.writeBadRequest(w, r, err)
+			return
+		}
+
+		signature, found := strings.CutPrefix(r.Header.Get(accrualSignatureHeader), "sha256=")
+		if !found {
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "signature required")
+			return
+		}
+		got, err := hex.DecodeString(signature)
+		if err != nil {
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "invalid signature")
+			return
+		}
+		mac := hmac.New(sha256.New, []byte(h.cfg.AccrualCallbackSecret))
+		mac.Write(body)
+		if !hmac.Equal(got, mac.Sum(nil)) {
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "invalid signature")
+			return
+		}
+
+		// тело уже прочитано - передаем хендлеру копию
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	}
+}
+
+type PostAccrualCallbackJSONRequest struct {
+	Order   string `json:"order"`
+	Status  string `json:"status"`
+	Accrual int    `json:"accrual"`
+}
+
+func (h *handler) PostAccrualCallback(w http.ResponseWriter, r *http.Request) {
+	var callbackJSON PostAccrualCallbackJSONRequest
+	err := json.NewDecoder(r.Body).Decode(&callbackJSON)
+	if err != nil {
+		h.writeBadRequest(w, r, err)
+		return
+	}
+
+	err = h.service.PostAccrual(r.Context(), accrualclient.AccrualAnswer{
+		Order:   callbackJSON.Order,
+		Status:  callbackJSON.Status,
+		Accrual: callbackJSON.Accrual})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
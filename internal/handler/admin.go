@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/audit"
+	"github.com/iurnickita/gophermart/internal/auth"
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/problem"
+	"github.com/iurnickita/gophermart/internal/service"
+)
+
+// adminActorKey - заголовок, в который adminMiddleware записывает исполнителя для журнала аудита
+const adminActorKey = "adminActor"
+
+// adminMiddleware пропускает запросы с общим токеном административного API (AdminToken)
+// или персональным токеном администратора, созданным gophermartctl
+func (h *handler) adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminToken, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var actor string
+		switch {
+		case !found || adminToken == "":
+		case h.cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(adminToken), []byte(h.cfg.AdminToken)) == 1:
+			actor = model.AuditActorAdmin
+		default:
+			admin, err := h.auth.AdminByToken(r.Context(), adminToken)
+			if err != nil && err != auth.ErrInvalidCredentials {
+				h.writeError(w, r, err)
+				return
+			}
+			if err == nil {
+				actor = model.AuditActorAdmin + ":" + admin.Login
+			}
+		}
+		if actor == "" {
+			h.audit.Record(r.Context(), audit.RequestEvent(r, model.AuditActionAdminRejected, "", r.URL.Path,
+				model.AuditOutcomeDenied, ""))
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "admin token required")
+			return
+		}
+		// при включенном mTLS нужен еще и сертификат клиента, подписанный CA администраторов
+		if h.cfg.AdminClientCAFile != "" && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			h.audit.Record(r.Context(), audit.RequestEvent(r, model.AuditActionAdminRejected, actor, r.URL.Path,
+				model.AuditOutcomeDenied, "client certificate required"))
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "admin client certificate required")
+			return
+		}
+		r.Header.Set(adminActorKey, actor)
+		next.ServeHTTP(w, r)
+	}
+}
+
+type PostWithdrawalReversalJSONRequest struct {
+	Customer  string `json:"customer"`
+	Operation string `json:"operation"`
+}
+
+type PostWithdrawalReversalJSONResponse struct {
+	Operation    string    `json:"operation"`
+	Reverses     string    `json:"reverses"`
+	Order        string    `json:"order"`
+	Sum          int       `json:"sum"`
+	Processed_at time.Time `json:"processed_at"`
+}
+
+// PostWithdrawalReversal возвращает пользователю баллы отмененного списания
+func (h *handler) PostWithdrawalReversal(w http.ResponseWriter, r *http.Request) {
+	var reversalJSON PostWithdrawalReversalJSONRequest
+	err := json.NewDecoder(r.Body).Decode(&reversalJSON)
+	if err != nil {
+		h.writeBadRequest(w, r, err)
+		return
+	}
+
+	// Несуществующий номер операции - такая же попытка отмены, в журнал аудита она тоже попадает
+	var reversal model.Balance
+	err = service.ErrNotFound
+	if validID(reversalJSON.Operation) {
+		reversal, err = h.service.ReverseWithdrawal(r.Context(), reversalJSON.Customer, reversalJSON.Operation)
+	}
+	h.recordAudit(r, model.AuditActionReversal, r.Header.Get(adminActorKey), reversalJSON.Operation, err,
+		"customer "+reversalJSON.Customer)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	responseJSON, err := json.Marshal(PostWithdrawalReversalJSONResponse{Operation: reversal.Key.Operation,
+		Reverses:     reversal.Data.Reference,
+		Order:        reversal.Data.Order,
+		Sum:          reversal.Data.Difference,
+		Processed_at: reversal.Data.Timestamp})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(responseJSON)
+}
+
+type PostHoldJSONRequest struct {
+	Customer string `json:"customer"`
+	Order    string `json:"order"`
+	Sum      int    `json:"sum"`
+	// Срок действия холда в секундах, 0 - по умолчанию
+	TTL int `json:"ttl,omitempty"`
+}
+
+type HoldJSONResponse struct {
+	ID        string    `json:"id"`
+	Customer  string    `json:"customer"`
+	Order     string    `json:"order"`
+	Sum       int       `json:"sum"`
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PostHold резервирует баллы пользователя на время оплаты заказа в магазине
+func (h *handler) PostHold(w http.ResponseWriter, r *http.Request) {
+	var holdJSON PostHoldJSONRequest
+	err := json.NewDecoder(r.Body).Decode(&holdJSON)
+	if err != nil {
+		h.writeBadRequest(w, r, err)
+		return
+	}
+
+	order := model.PurchaseOrder{
+		Number: holdJSON.Order,
+		Data:   model.PurchaseOrderData{Customer: holdJSON.Customer}}
+	hold, err := h.service.AuthorizeWithdrawal(r.Context(), order, holdJSON.Sum, time.Duration(holdJSON.TTL)*time.Second)
+	detail := fmt.Sprintf("customer %s, sum %d", holdJSON.Customer, holdJSON.Sum)
+	if err == nil {
+		detail += ", hold " + hold.ID
+	}
+	h.recordAudit(r, model.AuditActionHoldAuthorize, r.Header.Get(adminActorKey), holdJSON.Order, err, detail)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	responseJSON, err := json.Marshal(HoldJSONResponse{ID: hold.ID,
+		Customer:  hold.Data.Customer,
+		Order:     hold.Data.Order,
+		Sum:       hold.Data.Points,
+		Status:    hold.Data.Status,
+		ExpiresAt: hold.Data.ExpiresAt})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(responseJSON)
+}
+
+// PostHoldCapture подтверждает холд: зарезервированные баллы списываются
+func (h *handler) PostHoldCapture(w http.ResponseWriter, r *http.Request) {
+	var withdrawal model.Balance
+	err := service.ErrNotFound
+	if validID(r.PathValue("id")) {
+		withdrawal, err = h.service.CaptureWithdrawal(r.Context(), r.PathValue("id"))
+	}
+	h.recordAudit(r, model.AuditActionHoldCapture, r.Header.Get(adminActorKey), r.PathValue("id"), err, "")
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	responseJSON, err := json.Marshal(GetWithdrawalsJSONResponse{Operation: withdrawal.Key.Operation,
+		Order:        withdrawal.Data.Order,
+		Sum:          -withdrawal.Data.Difference,
+		Processed_at: withdrawal.Data.Timestamp})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
+
+// PostHoldRelease освобождает холд: зарезервированные баллы снова доступны
+func (h *handler) PostHoldRelease(w http.ResponseWriter, r *http.Request) {
+	err := service.ErrNotFound
+	if validID(r.PathValue("id")) {
+		err = h.service.ReleaseWithdrawal(r.Context(), r.PathValue("id"))
+	}
+	h.recordAudit(r, model.AuditActionHoldRelease, r.Header.Get(adminActorKey), r.PathValue("id"), err, "")
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type PostOrderRequeueJSONRequest struct {
+	// Минимальный возраст заказа в секундах, 0 - по умолчанию
+	OlderThan int `json:"older_than,omitempty"`
+}
+
+type PostOrderRequeueJSONResponse struct {
+	Requeued []GetOrderJSONResponse `json:"requeued"`
+}
+
+// PostOrderRequeue возобновляет опрос системы начислений по зависшим заказам NEW и PROCESSING.
+// Тело запроса необязательно
+func (h *handler) PostOrderRequeue(w http.ResponseWriter, r *http.Request) {
+	var requeueJSON PostOrderRequeueJSONRequest
+	err := json.NewDecoder(r.Body).Decode(&requeueJSON)
+	if err != nil && err != io.EOF {
+		h.writeBadRequest(w, r, err)
+		return
+	}
+
+	orders, err := h.service.RequeueOrders(r.Context(), time.Duration(requeueJSON.OlderThan)*time.Second)
+	h.recordAudit(r, model.AuditActionOrderRequeue, r.Header.Get(adminActorKey), "", err,
+		fmt.Sprintf("%d orders", len(orders)))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	response := PostOrderRequeueJSONResponse{Requeued: []GetOrderJSONResponse{}}
+	for _, order := range orders {
+		response.Requeued = append(response.Requeued, GetOrderJSONResponse{Number: order.Number,
+			Status:      order.Data.Status,
+			Accrual:     order.Data.Accrual,
+			Uploaded_at: order.Data.UploadedAt})
+	}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
+
+type PutOrderStatusJSONRequest struct {
+	Status  string `json:"status"`
+	Accrual int    `json:"accrual,omitempty"`
+}
+
+// PutOrderStatus устанавливает статус необработанного заказа вместо системы начислений
+func (h *handler) PutOrderStatus(w http.ResponseWriter, r *http.Request) {
+	var statusJSON PutOrderStatusJSONRequest
+	err := json.NewDecoder(r.Body).Decode(&statusJSON)
+	if err != nil {
+		h.writeBadRequest(w, r, err)
+		return
+	}
+
+	order, err := h.service.ForceOrderStatus(r.Context(), r.PathValue("number"), statusJSON.Status, statusJSON.Accrual)
+	h.recordAudit(r, model.AuditActionOrderStatus, r.Header.Get(adminActorKey), r.PathValue("number"), err,
+		fmt.Sprintf("status %s, accrual %d", statusJSON.Status, statusJSON.Accrual))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	responseJSON, err := json.Marshal(GetOrderJSONResponse{Number: order.Number,
+		Status:      order.Data.Status,
+		Accrual:     order.Data.Accrual,
+		Uploaded_at: order.Data.UploadedAt})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
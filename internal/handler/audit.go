@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/audit"
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/problem"
+)
+
+// recordAudit записывает в журнал аудита результат действия: успех или ошибку с ее текстом
+func (h *handler) recordAudit(r *http.Request, action string, actor string, target string, err error, detail string) {
+	outcome := model.AuditOutcomeSuccess
+	if err != nil {
+		outcome = model.AuditOutcomeFailure
+		if detail != "" {
+			detail += ": "
+		}
+		detail += err.Error()
+	}
+	h.audit.Record(r.Context(), audit.RequestEvent(r, action, actor, target, outcome, detail))
+}
+
+// Размер страницы журнала аудита по умолчанию и максимальный
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type GetAuditJSONResponse struct {
+	Events []audit.EventJSON `json:"events"`
+	// Значение before для следующей страницы
+	Next string `json:"next,omitempty"`
+}
+
+// GetAudit возвращает записи журнала аудита от новых к старым.
+// Параметры отбора: actor, action, target, outcome, from и to (RFC 3339), before, limit
+func (h *handler) GetAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := model.AuditFilter{
+		Actor:    query.Get("actor"),
+		Action:   query.Get("action"),
+		Target:   query.Get("target"),
+		Outcome:  query.Get("outcome"),
+		BeforeID: query.Get("before"),
+	}
+	var err error
+	if from := query.Get("from"); from != "" {
+		filter.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeBadRequest, "from: expected RFC 3339 time")
+			return
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		filter.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeBadRequest, "to: expected RFC 3339 time")
+			return
+		}
+	}
+	if filter.BeforeID != "" {
+		if _, err = strconv.ParseInt(filter.BeforeID, 10, 64); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeBadRequest, "before: expected event id")
+			return
+		}
+	}
+	filter.Limit = defaultAuditLimit
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeBadRequest, "limit: expected positive number")
+			return
+		}
+		filter.Limit = min(filter.Limit, maxAuditLimit)
+	}
+
+	events, err := h.audit.Get(r.Context(), filter)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	response := GetAuditJSONResponse{Events: make([]audit.EventJSON, 0, len(events))}
+	for _, event := range events {
+		response.Events = append(response.Events, audit.NewEventJSON(event))
+	}
+	if len(events) == filter.Limit {
+		response.Next = events[len(events)-1].ID
+	}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/iurnickita/gophermart/internal/auth"
+	"github.com/iurnickita/gophermart/internal/problem"
+)
+
+type PostOrderBatchJSONResponse struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+}
+
+// PostOrderBatch принимает пакет номеров заказов:
+// JSON-массив строк (application/json) или CSV с номером в первой колонке (text/csv)
+func (h *handler) PostOrderBatch(w http.ResponseWriter, r *http.Request) {
+	var numbers []string
+	var err error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		err = json.NewDecoder(r.Body).Decode(&numbers)
+	case "text/csv":
+		numbers, err = readOrderNumbersCSV(r.Body)
+	default:
+		problem.Write(w, r, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType, "expected application/json or text/csv")
+		return
+	}
+	if err != nil {
+		h.writeBadRequest(w, r, err)
+		return
+	}
+
+	userCode := r.Header.Get(auth.UserCodeKey)
+
+	results, err := h.service.PostOrderBatch(r.Context(), userCode, numbers)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	resultsJSON := make([]PostOrderBatchJSONResponse, 0, len(results))
+	for _, result := range results {
+		resultsJSON = append(resultsJSON,
+			PostOrderBatchJSONResponse{Number: result.Number,
+				Result: result.Result})
+	}
+	responseJSON, err := json.Marshal(resultsJSON)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
+
+// readOrderNumbersCSV читает номера из первой колонки CSV.
+// Пустые строки и строка заголовка "number" пропускаются.
+func readOrderNumbersCSV(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	var numbers []string
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return numbers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		number := strings.TrimSpace(record[0])
+		if number == "" || (first && strings.EqualFold(number, "number")) {
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+}
@@ -1,5 +1,44 @@
 package config
 
+import (
+	"time"
+
+	compressConfig "github.com/iurnickita/gophermart/internal/compress/config"
+)
+
 type Config struct {
 	ServerAddr string
+	// Период heartbeat-сообщений в потоке статусов заказов
+	StreamHeartbeat time.Duration
+	// Общий секрет для подписи push-уведомлений системы начислений.
+	// Пустое значение - прием уведомлений отключен
+	AccrualCallbackSecret string
+	// Общий токен доступа к административному API (заголовок Authorization: Bearer <token>).
+	// Пустое значение - доступ только по персональным токенам администраторов (gophermartctl admins create)
+	AdminToken string
+	// Таймаут проверки зависимостей в /readyz
+	ReadinessTimeout time.Duration
+	// Сжатие запросов и ответов
+	Compress compressConfig.Config
+
+	// TLS. Пустой TLSCertFile - сервер принимает HTTP без шифрования
+	TLSCertFile string
+	TLSKeyFile  string
+	// Минимальная версия TLS: "1.2" (по умолчанию) или "1.3"
+	TLSMinVersion string
+	// Наборы шифров TLS 1.2 по именам crypto/tls, напр. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+	// Пустое значение - безопасный набор Go по умолчанию. Для HTTP/2 список должен содержать
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 или TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+	TLSCipherSuites []string
+	// Период проверки файлов сертификата на изменение
+	TLSReloadInterval time.Duration
+	// Сертификаты CA клиентов административного API (mTLS).
+	// Пустое значение - сертификат клиента не требуется
+	AdminClientCAFile string
+	// Адрес HTTP-сервера, перенаправляющего запросы на HTTPS. Пустое значение - не запускается
+	RedirectAddr string
+	// HTTP/2 без TLS (h2c) для работы за прокси. С TLS HTTP/2 включен всегда
+	HTTP2Cleartext bool
+	// Время на завершение начатых запросов при остановке сервера
+	ShutdownTimeout time.Duration
 }
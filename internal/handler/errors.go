@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/iurnickita/gophermart/internal/logger"
+	"github.com/iurnickita/gophermart/internal/problem"
+	"github.com/iurnickita/gophermart/internal/service"
+	"go.uber.org/zap"
+)
+
+// serviceErrors - соответствие ошибок сервиса HTTP-статусам и кодам ошибок
+var serviceErrors = map[error]struct {
+	status int
+	code   string
+}{
+	service.ErrInsufficientData:    {http.StatusBadRequest, problem.CodeInsufficientData},
+	service.ErrUnprocessableEntity: {http.StatusUnprocessableEntity, problem.CodeUnprocessableEntity},
+	service.ErrAlreadyExists:       {http.StatusConflict, problem.CodeAlreadyExists},
+	service.ErrDuplicateRequest:    {http.StatusConflict, problem.CodeAlreadyExists},
+	service.ErrInsufficientFunds:   {http.StatusPaymentRequired, problem.CodeInsufficientFunds},
+	service.ErrNotFound:            {http.StatusNotFound, problem.CodeNotFound},
+	service.ErrConflict:            {http.StatusConflict, problem.CodeConflict},
+}
+
+// writeError отправляет ответ об ошибке сервиса.
+// Неизвестные ошибки логируются и отдаются клиенту как внутренняя ошибка без подробностей.
+func (h *handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if mapped, ok := serviceErrors[err]; ok {
+		problem.Write(w, r, mapped.status, mapped.code, err.Error())
+		return
+	}
+
+	logger.FromContext(r.Context()).Error("request failed",
+		zap.String("path", r.URL.Path),
+		zap.Error(err),
+	)
+	problem.WriteInternal(w, r)
+}
+
+// writeBadRequest отправляет ответ о некорректном теле запроса
+func (h *handler) writeBadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	problem.WriteBadRequest(w, r, err)
+}
+
+// validID проверяет идентификатор из запроса: вебхуки, операции журнала и холды нумеруются
+// SERIAL, поэтому другого значения не существует и в запрос к БД оно не передается
+func validID(id string) bool {
+	_, err := strconv.ParseInt(id, 10, 32)
+	return err == nil
+}
@@ -2,57 +2,186 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/iurnickita/gophermart/internal/audit"
 	"github.com/iurnickita/gophermart/internal/auth"
-	"github.com/iurnickita/gophermart/internal/gzip"
+	"github.com/iurnickita/gophermart/internal/compress"
 	"github.com/iurnickita/gophermart/internal/handler/config"
 	"github.com/iurnickita/gophermart/internal/logger"
 	"github.com/iurnickita/gophermart/internal/model"
 	"github.com/iurnickita/gophermart/internal/service"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
-func Serve(cfg config.Config, auth auth.Auth, service service.Service, zaplog *zap.Logger) error {
-	h := newHandler(auth, service, cfg.ServerAddr, zaplog)
+// Serve запускает HTTP-сервер и останавливает его после отмены ctx,
+// давая начатым запросам ShutdownTimeout на завершение
+func Serve(ctx context.Context, cfg config.Config, auth auth.Auth, service service.Service, audit audit.Audit, levels logger.Levels, zaplog *zap.Logger) error {
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+	h := newHandler(cfg, auth, service, audit, levels, zaplog)
 	router := h.newRouter()
 
 	srv := &http.Server{
 		Addr:    cfg.ServerAddr,
-		Handler: router,
+		Handler: tracedRouter(router),
+	}
+	servers := []*http.Server{srv}
+
+	var serve func() error
+	if cfg.TLSCertFile == "" {
+		if cfg.AdminClientCAFile != "" {
+			return errors.New("admin mTLS requires TLS")
+		}
+		if cfg.HTTP2Cleartext {
+			srv.Handler = h2c.NewHandler(srv.Handler, &http2.Server{})
+		}
+		serve = srv.ListenAndServe
+	} else {
+		tlsConfig, err := newTLSConfig(ctx, cfg, zaplog)
+		if err != nil {
+			return err
+		}
+		// HTTP/2 согласуется через ALPN: net/http добавляет "h2" в NextProtos
+		srv.TLSConfig = tlsConfig
+
+		if cfg.RedirectAddr != "" {
+			redirect := &http.Server{Addr: cfg.RedirectAddr, Handler: redirectHandler(cfg.ServerAddr)}
+			servers = append(servers, redirect)
+			go func() {
+				err := redirect.ListenAndServe()
+				if err != http.ErrServerClosed {
+					zaplog.Error("https redirect server stopped", zap.Error(err))
+				}
+			}()
+		}
+
+		// сертификат выдает tlsConfig.GetCertificate
+		serve = func() error { return srv.ListenAndServeTLS("", "") }
 	}
 
-	return srv.ListenAndServe()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		for _, server := range servers {
+			// потоки статусов заказов не завершаются сами - их обрывает Close по таймауту
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				server.Close()
+			}
+		}
+	}()
+
+	err := serve()
+	if err != http.ErrServerClosed {
+		return err
+	}
+	<-stopped
+	return nil
+}
+
+// tracedRouter создает span на каждый запрос, продолжая трассу из заголовка traceparent.
+// Span называется по шаблону маршрута, напр. "GET /api/user/orders"
+func tracedRouter(router *http.ServeMux) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := router.Handler(r); pattern != "" {
+			trace.SpanFromContext(r.Context()).SetName(pattern)
+		}
+		router.ServeHTTP(w, r)
+	})
+	return otelhttp.NewHandler(named, "http.request")
 }
 
 type handler struct {
-	auth     auth.Auth
-	service  service.Service
-	baseaddr string
-	zaplog   *zap.Logger
+	cfg        config.Config
+	auth       auth.Auth
+	service    service.Service
+	compressor compress.Compressor
+	audit      audit.Audit
+	levels     logger.Levels
+	baseaddr   string
+	zaplog     *zap.Logger
 }
 
-func newHandler(auth auth.Auth, service service.Service, baseaddr string, zaplog *zap.Logger) *handler {
+const (
+	defaultStreamHeartbeat  = 15 * time.Second
+	defaultReadinessTimeout = 2 * time.Second
+	defaultShutdownTimeout  = 10 * time.Second
+)
+
+func newHandler(cfg config.Config, auth auth.Auth, service service.Service, audit audit.Audit, levels logger.Levels, zaplog *zap.Logger) *handler {
+	if cfg.StreamHeartbeat <= 0 {
+		cfg.StreamHeartbeat = defaultStreamHeartbeat
+	}
+	if cfg.ReadinessTimeout <= 0 {
+		cfg.ReadinessTimeout = defaultReadinessTimeout
+	}
 	return &handler{
-		auth:     auth,
-		service:  service,
-		baseaddr: baseaddr,
-		zaplog:   zaplog,
+		cfg:        cfg,
+		auth:       auth,
+		service:    service,
+		compressor: compress.NewCompressor(cfg.Compress),
+		audit:      audit,
+		levels:     levels,
+		baseaddr:   cfg.ServerAddr,
+		zaplog:     zaplog,
 	}
 }
 
 func (h *handler) newRouter() *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /api/user/register", logger.RequestLogMdlw(gzip.GzipMiddleware(h.auth.Register), h.zaplog))
-	mux.HandleFunc("POST /api/user/login", logger.RequestLogMdlw(gzip.GzipMiddleware(h.auth.Login), h.zaplog))
-	mux.HandleFunc("POST /api/user/orders", logger.RequestLogMdlw(gzip.GzipMiddleware(h.auth.Middleware(h.PostOrder)), h.zaplog))
-	mux.HandleFunc("GET /api/user/orders", logger.RequestLogMdlw(gzip.GzipMiddleware(h.auth.Middleware(h.GetOrder)), h.zaplog))
-	mux.HandleFunc("GET /api/user/balance", logger.RequestLogMdlw(gzip.GzipMiddleware(h.auth.Middleware(h.GetBalance)), h.zaplog))
-	mux.HandleFunc("POST /api/user/balance/withdraw", logger.RequestLogMdlw(gzip.GzipMiddleware(h.auth.Middleware(h.PostWithdraw)), h.zaplog))
-	mux.HandleFunc("GET /api/user/withdrawals", logger.RequestLogMdlw(gzip.GzipMiddleware(h.auth.Middleware(h.GetWithdrawals)), h.zaplog))
+	// проверки оркестратора не логируются и не требуют авторизации
+	mux.HandleFunc("GET /healthz", h.GetHealthz)
+	mux.HandleFunc("GET /readyz", h.GetReadyz)
+
+	mux.HandleFunc("POST /api/user/register", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Register), h.zaplog))
+	mux.HandleFunc("POST /api/user/login", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Login), h.zaplog))
+	mux.HandleFunc("POST /api/user/orders", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.PostOrder)), h.zaplog))
+	mux.HandleFunc("POST /api/user/orders/batch", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.PostOrderBatch)), h.zaplog))
+	mux.HandleFunc("GET /api/user/orders", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.GetOrder)), h.zaplog))
+	// поток не сжимается: компрессор буферизует данные и мешает доставке событий
+	mux.HandleFunc("GET /api/user/orders/stream", logger.RequestLogMdlw(h.auth.Middleware(h.GetOrderStream), h.zaplog))
+	mux.HandleFunc("GET /api/user/profile", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.GetProfile)), h.zaplog))
+	mux.HandleFunc("GET /api/user/referrals", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.GetReferrals)), h.zaplog))
+	mux.HandleFunc("GET /api/user/balance", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.GetBalance)), h.zaplog))
+	mux.HandleFunc("POST /api/user/balance/withdraw", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.PostWithdraw)), h.zaplog))
+	mux.HandleFunc("GET /api/user/statement", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.GetStatement)), h.zaplog))
+	mux.HandleFunc("GET /api/user/withdrawals", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.GetWithdrawals)), h.zaplog))
+	mux.HandleFunc("POST /api/user/webhooks", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.PostWebhook)), h.zaplog))
+	mux.HandleFunc("GET /api/user/webhooks", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.GetWebhooks)), h.zaplog))
+	mux.HandleFunc("DELETE /api/user/webhooks/{id}", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.DeleteWebhook)), h.zaplog))
+	mux.HandleFunc("GET /api/user/webhooks/{id}/deliveries", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.GetWebhookDeliveries)), h.zaplog))
+	mux.HandleFunc("POST /api/user/webhooks/{id}/ping", logger.RequestLogMdlw(h.compressor.Middleware(h.auth.Middleware(h.PingWebhook)), h.zaplog))
+
+	// API для администраторов и магазина
+	mux.HandleFunc("POST /api/admin/withdrawals/reversal", logger.RequestLogMdlw(h.compressor.Middleware(h.adminMiddleware(h.PostWithdrawalReversal)), h.zaplog))
+	mux.HandleFunc("POST /api/admin/holds", logger.RequestLogMdlw(h.compressor.Middleware(h.adminMiddleware(h.PostHold)), h.zaplog))
+	mux.HandleFunc("POST /api/admin/holds/{id}/capture", logger.RequestLogMdlw(h.compressor.Middleware(h.adminMiddleware(h.PostHoldCapture)), h.zaplog))
+	mux.HandleFunc("POST /api/admin/holds/{id}/release", logger.RequestLogMdlw(h.compressor.Middleware(h.adminMiddleware(h.PostHoldRelease)), h.zaplog))
+	mux.HandleFunc("POST /api/admin/orders/requeue", logger.RequestLogMdlw(h.compressor.Middleware(h.adminMiddleware(h.PostOrderRequeue)), h.zaplog))
+	mux.HandleFunc("PUT /api/admin/orders/{number}/status", logger.RequestLogMdlw(h.compressor.Middleware(h.adminMiddleware(h.PutOrderStatus)), h.zaplog))
+	mux.HandleFunc("GET /api/admin/log/level", logger.RequestLogMdlw(h.compressor.Middleware(h.adminMiddleware(h.GetLogLevel)), h.zaplog))
+	mux.HandleFunc("PUT /api/admin/log/level", logger.RequestLogMdlw(h.compressor.Middleware(h.adminMiddleware(h.PutLogLevel)), h.zaplog))
+	mux.HandleFunc("GET /api/admin/metrics", logger.RequestLogMdlw(h.compressor.Middleware(h.adminMiddleware(expvar.Handler().ServeHTTP)), h.zaplog))
+	mux.HandleFunc("GET /api/admin/audit", logger.RequestLogMdlw(h.compressor.Middleware(h.adminMiddleware(h.GetAudit)), h.zaplog))
+
+	if h.cfg.AccrualCallbackSecret != "" {
+		mux.HandleFunc("POST /api/accrual/callback", logger.RequestLogMdlw(h.compressor.Middleware(h.accrualSignatureMiddleware(h.PostAccrualCallback)), h.zaplog))
+	}
 
 	return mux
 }
@@ -60,7 +189,7 @@ func (h *handler) newRouter() *http.ServeMux {
 func (h *handler) PostOrder(w http.ResponseWriter, r *http.Request) {
 	number, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeBadRequest(w, r, err)
 		return
 	}
 
@@ -68,20 +197,14 @@ func (h *handler) PostOrder(w http.ResponseWriter, r *http.Request) {
 
 	order := model.PurchaseOrder{Number: string(number),
 		Data: model.PurchaseOrderData{Customer: userCode}}
-	err = h.service.PostOrder(order)
-	if err != nil {
-		switch err {
-		case service.ErrInsufficientData:
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		case service.ErrUnprocessableEntity:
-			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
-		case service.ErrAlreadyExists:
-			http.Error(w, err.Error(), http.StatusConflict)
-		case service.ErrDuplicateRequest:
+	err = h.service.PostOrder(r.Context(), order)
+	if err != nil {
+		// повторная загрузка своего заказа - не ошибка
+		if err == service.ErrDuplicateRequest {
 			w.WriteHeader(http.StatusOK)
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		h.writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusAccepted)
@@ -97,13 +220,13 @@ type GetOrderJSONResponse struct {
 func (h *handler) GetOrder(w http.ResponseWriter, r *http.Request) {
 	userCode := r.Header.Get(auth.UserCodeKey)
 
-	orders, err := h.service.GetOrder(userCode)
+	orders, err := h.service.GetOrder(r.Context(), userCode)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 	if len(orders) == 0 {
-		http.Error(w, "", http.StatusNoContent)
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
@@ -117,32 +240,35 @@ func (h *handler) GetOrder(w http.ResponseWriter, r *http.Request) {
 	}
 	responseJSON, err := json.Marshal(ordersJSON)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(responseJSON)
 }
 
+// Current - баллы, доступные для списания: баланс за вычетом холдов
 type GetBalanceJSONResponse struct {
 	Current   int `json:"current"`
 	Withdrawn int `json:"withdrawn"`
+	OnHold    int `json:"on_hold"`
 }
 
 func (h *handler) GetBalance(w http.ResponseWriter, r *http.Request) {
 	userCode := r.Header.Get(auth.UserCodeKey)
 
-	balance, err := h.service.GetBalance(userCode)
+	balance, err := h.service.GetBalance(r.Context(), userCode)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 
-	balanceJSON := GetBalanceJSONResponse{Current: balance.Data.Balance,
-		Withdrawn: balance.Data.Withdrawn}
+	balanceJSON := GetBalanceJSONResponse{Current: balance.Data.Balance - balance.Data.OnHold,
+		Withdrawn: balance.Data.Withdrawn,
+		OnHold:    balance.Data.OnHold}
 	responseJSON, err := json.Marshal(balanceJSON)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -158,14 +284,14 @@ func (h *handler) PostWithdraw(w http.ResponseWriter, r *http.Request) {
 	var buf bytes.Buffer
 	_, err := buf.ReadFrom(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeBadRequest(w, r, err)
 		return
 	}
 
 	var withdrawJSON PostWithdrawJSONRequest
 	err = json.Unmarshal(buf.Bytes(), &withdrawJSON)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeBadRequest(w, r, err)
 		return
 	}
 
@@ -174,49 +300,204 @@ func (h *handler) PostWithdraw(w http.ResponseWriter, r *http.Request) {
 	order := model.PurchaseOrder{
 		Number: withdrawJSON.Order,
 		Data:   model.PurchaseOrderData{Customer: userCode}}
-	err = h.service.PostWithdraw(order, withdrawJSON.Sum)
-	if err != nil {
-		switch err {
-		case service.ErrInsufficientFunds:
-			http.Error(w, err.Error(), http.StatusPaymentRequired)
-		case service.ErrUnprocessableEntity:
-			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	err = h.service.PostWithdraw(r.Context(), order, withdrawJSON.Sum)
+	h.recordAudit(r, model.AuditActionWithdraw, userCode, withdrawJSON.Order, err, "sum "+strconv.Itoa(withdrawJSON.Sum))
+	if err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 }
 
 type GetWithdrawalsJSONResponse struct {
+	Operation    string    `json:"operation"`
 	Order        string    `json:"order"`
 	Sum          int       `json:"sum"`
 	Processed_at time.Time `json:"processed_at"`
+	Reversed     bool      `json:"reversed,omitempty"`
 }
 
 func (h *handler) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 	userCode := r.Header.Get(auth.UserCodeKey)
 
-	withdrawals, err := h.service.GetWithdrawals(userCode)
+	withdrawals, err := h.service.GetWithdrawals(r.Context(), userCode)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 	if len(withdrawals) == 0 {
-		http.Error(w, "", http.StatusNoContent)
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
 	var withdrawalsJSON []GetWithdrawalsJSONResponse
 	for _, withdraw := range withdrawals {
 		withdrawalsJSON = append(withdrawalsJSON,
-			GetWithdrawalsJSONResponse{Order: withdraw.Data.Order,
+			GetWithdrawalsJSONResponse{Operation: withdraw.Key.Operation,
+				Order:        withdraw.Data.Order,
 				Sum:          -withdraw.Data.Difference,
-				Processed_at: withdraw.Data.Timestamp})
+				Processed_at: withdraw.Data.Timestamp,
+				Reversed:     withdraw.Data.Reversed})
 	}
 	responseJSON, err := json.Marshal(withdrawalsJSON)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
+
+type PostWebhookJSONRequest struct {
+	URL string `json:"url"`
+}
+
+type WebhookJSONResponse struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (h *handler) PostWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhookJSON PostWebhookJSONRequest
+	err := json.NewDecoder(r.Body).Decode(&webhookJSON)
+	if err != nil {
+		h.writeBadRequest(w, r, err)
+		return
+	}
+
+	userCode := r.Header.Get(auth.UserCodeKey)
+
+	webhook, err := h.service.PostWebhook(r.Context(), userCode, webhookJSON.URL)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	// секрет возвращается только при регистрации
+	responseJSON, err := json.Marshal(WebhookJSONResponse{ID: webhook.ID,
+		URL:       webhook.Data.URL,
+		Secret:    webhook.Data.Secret,
+		CreatedAt: webhook.Data.CreatedAt})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(responseJSON)
+}
+
+func (h *handler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	userCode := r.Header.Get(auth.UserCodeKey)
+
+	webhooks, err := h.service.GetWebhooks(r.Context(), userCode)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if len(webhooks) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var webhooksJSON []WebhookJSONResponse
+	for _, webhook := range webhooks {
+		webhooksJSON = append(webhooksJSON,
+			WebhookJSONResponse{ID: webhook.ID,
+				URL:       webhook.Data.URL,
+				CreatedAt: webhook.Data.CreatedAt})
+	}
+	responseJSON, err := json.Marshal(webhooksJSON)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
+
+func (h *handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userCode := r.Header.Get(auth.UserCodeKey)
+	if !validID(r.PathValue("id")) {
+		h.writeError(w, r, service.ErrNotFound)
+		return
+	}
+
+	err := h.service.DeleteWebhook(r.Context(), userCode, r.PathValue("id"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type WebhookDeliveryJSONResponse struct {
+	Event      string    `json:"event"`
+	Order      string    `json:"order,omitempty"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+func newWebhookDeliveryJSON(delivery model.WebhookDelivery) WebhookDeliveryJSONResponse {
+	return WebhookDeliveryJSONResponse{Event: delivery.Data.Event,
+		Order:      delivery.Data.Order,
+		Attempt:    delivery.Data.Attempt,
+		StatusCode: delivery.Data.StatusCode,
+		Error:      delivery.Data.Error,
+		Success:    delivery.Data.Success,
+		Timestamp:  delivery.Data.Timestamp}
+}
+
+func (h *handler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userCode := r.Header.Get(auth.UserCodeKey)
+	if !validID(r.PathValue("id")) {
+		h.writeError(w, r, service.ErrNotFound)
+		return
+	}
+
+	deliveries, err := h.service.GetWebhookDeliveries(r.Context(), userCode, r.PathValue("id"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if len(deliveries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var deliveriesJSON []WebhookDeliveryJSONResponse
+	for _, delivery := range deliveries {
+		deliveriesJSON = append(deliveriesJSON, newWebhookDeliveryJSON(delivery))
+	}
+	responseJSON, err := json.Marshal(deliveriesJSON)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
+
+func (h *handler) PingWebhook(w http.ResponseWriter, r *http.Request) {
+	userCode := r.Header.Get(auth.UserCodeKey)
+	if !validID(r.PathValue("id")) {
+		h.writeError(w, r, service.ErrNotFound)
+		return
+	}
+
+	delivery, err := h.service.PingWebhook(r.Context(), userCode, r.PathValue("id"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	responseJSON, err := json.Marshal(newWebhookDeliveryJSON(delivery))
+	if err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/iurnickita/gophermart/internal/logger"
+	"github.com/iurnickita/gophermart/internal/service"
+	"go.uber.org/zap"
+)
+
+type HealthJSONResponse struct {
+	Status string                         `json:"status"`
+	Checks map[string]DependencyJSONCheck `json:"checks,omitempty"`
+}
+
+type DependencyJSONCheck struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Circuit   string  `json:"circuit,omitempty"`
+}
+
+// GetHealthz - liveness: процесс запущен и обрабатывает запросы
+func (h *handler) GetHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, HealthJSONResponse{Status: service.DependencyStatusUp})
+}
+
+// GetReadyz - readiness: доступны БД и система начислений, схема БД актуальной версии.
+// При недоступности любой зависимости возвращается 503.
+// Для системы начислений дополнительно отдается состояние выключателя запросов.
+// Текст ошибок только пишется в лог: эндпоинт доступен без авторизации
+func (h *handler) GetReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.ReadinessTimeout)
+	defer cancel()
+
+	response := HealthJSONResponse{Status: service.DependencyStatusUp,
+		Checks: make(map[string]DependencyJSONCheck)}
+	statusCode := http.StatusOK
+	for _, check := range h.service.CheckDependencies(ctx) {
+		response.Checks[check.Name] = DependencyJSONCheck{Status: check.Status,
+			LatencyMs: float64(check.Latency.Microseconds()) / 1000,
+			Circuit:   check.Circuit}
+		if check.Status != service.DependencyStatusUp {
+			logger.FromContext(r.Context()).Warn("readiness check failed",
+				zap.String("dependency", check.Name),
+				zap.String("status", check.Status),
+				zap.String("error", check.Error))
+			response.Status = service.DependencyStatusDown
+			statusCode = http.StatusServiceUnavailable
+		}
+	}
+
+	writeHealth(w, statusCode, response)
+}
+
+func writeHealth(w http.ResponseWriter, statusCode int, response HealthJSONResponse) {
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	w.Write(responseJSON)
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/iurnickita/gophermart/internal/logger"
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/problem"
+	"go.uber.org/zap"
+)
+
+type PutLogLevelJSONRequest struct {
+	Component string `json:"component"`
+	Level     string `json:"level"`
+}
+
+// GetLogLevel возвращает уровни логирования компонентов
+func (h *handler) GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	h.writeLogLevels(w, r)
+}
+
+// PutLogLevel меняет уровень логирования компонента без перезапуска
+func (h *handler) PutLogLevel(w http.ResponseWriter, r *http.Request) {
+	var levelJSON PutLogLevelJSONRequest
+	err := json.NewDecoder(r.Body).Decode(&levelJSON)
+	if err != nil {
+		h.writeBadRequest(w, r, err)
+		return
+	}
+	if levelJSON.Component == "" {
+		levelJSON.Component = logger.ComponentDefault
+	}
+
+	err = h.levels.Set(levelJSON.Component, levelJSON.Level)
+	h.recordAudit(r, model.AuditActionLogLevel, r.Header.Get(adminActorKey), levelJSON.Component, err, levelJSON.Level)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeBadRequest, err.Error())
+		return
+	}
+	logger.FromContext(r.Context()).Warn("log level changed",
+		zap.String("component", levelJSON.Component),
+		zap.String("level", levelJSON.Level))
+
+	h.writeLogLevels(w, r)
+}
+
+func (h *handler) writeLogLevels(w http.ResponseWriter, r *http.Request) {
+	responseJSON, err := json.Marshal(h.levels.Get())
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/iurnickita/gophermart/internal/auth"
+)
+
+type GetProfileJSONResponse struct {
+	Tier             string `json:"tier"`
+	Multiplier       int    `json:"multiplier"`
+	LifetimeAccrued  int    `json:"lifetime_accrued"`
+	NextTier         string `json:"next_tier,omitempty"`
+	PointsToNextTier int    `json:"points_to_next_tier,omitempty"`
+}
+
+func (h *handler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	userCode := r.Header.Get(auth.UserCodeKey)
+
+	profile, err := h.service.GetProfile(r.Context(), userCode)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	profileJSON := GetProfileJSONResponse{Tier: profile.Tier.Level.Name,
+		Multiplier:      profile.Tier.Level.Multiplier,
+		LifetimeAccrued: profile.Tier.LifetimeAccrued}
+	if next := profile.Tier.Next; next != nil {
+		profileJSON.NextTier = next.Name
+		profileJSON.PointsToNextTier = max(next.Threshold-profile.Tier.LifetimeAccrued, 0)
+	}
+	responseJSON, err := json.Marshal(profileJSON)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
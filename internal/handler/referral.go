@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/auth"
+)
+
+type ReferralJSONResponse struct {
+	RegisteredAt time.Time  `json:"registered_at"`
+	RewardedAt   *time.Time `json:"rewarded_at,omitempty"`
+	Bonus        int        `json:"bonus,omitempty"`
+}
+
+type GetReferralsJSONResponse struct {
+	Code       string                 `json:"code"`
+	BonusTotal int                    `json:"bonus_total"`
+	Referrals  []ReferralJSONResponse `json:"referrals"`
+}
+
+func (h *handler) GetReferrals(w http.ResponseWriter, r *http.Request) {
+	userCode := r.Header.Get(auth.UserCodeKey)
+
+	summary, err := h.service.GetReferrals(r.Context(), userCode)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	referralsJSON := GetReferralsJSONResponse{Code: summary.Code,
+		BonusTotal: summary.BonusTotal,
+		Referrals:  []ReferralJSONResponse{}}
+	for _, invite := range summary.Referrals {
+		inviteJSON := ReferralJSONResponse{RegisteredAt: invite.Data.CreatedAt,
+			Bonus: invite.Data.ReferrerBonus}
+		if !invite.Data.RewardedAt.IsZero() {
+			rewardedAt := invite.Data.RewardedAt
+			inviteJSON.RewardedAt = &rewardedAt
+		}
+		referralsJSON.Referrals = append(referralsJSON.Referrals, inviteJSON)
+	}
+	responseJSON, err := json.Marshal(referralsJSON)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseJSON)
+}
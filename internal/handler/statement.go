@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/auth"
+	"github.com/iurnickita/gophermart/internal/logger"
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/problem"
+	"github.com/iurnickita/gophermart/internal/service"
+	"go.uber.org/zap"
+)
+
+// Форматы выписки
+const (
+	statementFormatCSV  = "csv"
+	statementFormatJSON = "json"
+)
+
+const statementDateLayout = "2006-01-02"
+
+// GetStatement отдает выписку по счету за период: входящий остаток, операции журнала
+// с заказами и исходящий остаток. Параметры: from и to (RFC 3339 или дата ГГГГ-ММ-ДД,
+// дата в to включается целиком), format - csv (по умолчанию) или json.
+// Выписка формируется потоком, без загрузки всего периода в память
+func (h *handler) GetStatement(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, err := parseStatementTime(query.Get("from"), false)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeBadRequest, "from: expected RFC 3339 time or date")
+		return
+	}
+	to := time.Now()
+	if query.Get("to") != "" {
+		to, err = parseStatementTime(query.Get("to"), true)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeBadRequest, "to: expected RFC 3339 time or date")
+			return
+		}
+	}
+
+	userCode := r.Header.Get(auth.UserCodeKey)
+
+	var sw statementWriter
+	switch format := query.Get("format"); format {
+	case "", statementFormatCSV:
+		sw = &csvStatementWriter{w: w, csv: csv.NewWriter(w)}
+	case statementFormatJSON:
+		sw = &jsonStatementWriter{w: w, customer: userCode, from: from, to: to}
+	default:
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeBadRequest, "format: expected csv or json")
+		return
+	}
+	sw.setFilename(statementFilename(from, to, sw.extension()))
+
+	err = h.service.GetStatement(r.Context(), userCode, from, to, sw)
+	if err != nil {
+		if !sw.started() {
+			h.writeError(w, r, err)
+			return
+		}
+		// заголовки и часть выписки уже отправлены: обрываем ответ,
+		// чтобы клиент не принял неполную выписку за полную
+		logger.FromContext(r.Context()).Error("statement aborted", zap.Error(err))
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// parseStatementTime разбирает границу периода. Дата в конце периода означает конец этого дня
+func parseStatementTime(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(statementDateLayout, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func statementFilename(from time.Time, to time.Time, extension string) string {
+	fromName := "start"
+	if !from.IsZero() {
+		fromName = from.Format(statementDateLayout)
+	}
+	// to не входит в период, в имени - последний день выписки
+	return fmt.Sprintf("statement_%s_%s.%s", fromName, to.Add(-time.Nanosecond).Format(statementDateLayout), extension)
+}
+
+type statementWriter interface {
+	service.StatementWriter
+	extension() string
+	setFilename(filename string)
+	// started - ответ уже начат, ошибку нельзя отправить как problem+json
+	started() bool
+}
+
+// statementHeader отправляет заголовки ответа с выпиской
+func statementHeader(w http.ResponseWriter, contentType string, filename string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// Строки выписки CSV: входящий остаток, операции, исходящий остаток
+var statementCSVHeader = []string{"timestamp", "operation", "kind", "order", "order_status", "order_accrual",
+	"reference", "amount", "balance", "withdrawn"}
+
+const (
+	statementKindOpening = "OPENING_BALANCE"
+	statementKindClosing = "CLOSING_BALANCE"
+)
+
+type csvStatementWriter struct {
+	w        http.ResponseWriter
+	csv      *csv.Writer
+	filename string
+	begun    bool
+}
+
+func (s *csvStatementWriter) extension() string           { return statementFormatCSV }
+func (s *csvStatementWriter) setFilename(filename string) { s.filename = filename }
+func (s *csvStatementWriter) started() bool               { return s.begun }
+
+func (s *csvStatementWriter) Opening(totals service.StatementTotals) error {
+	s.begun = true
+	statementHeader(s.w, "text/csv; charset=utf-8", s.filename)
+	s.csv.Write(statementCSVHeader)
+	return s.totals(statementKindOpening, totals)
+}
+
+func (s *csvStatementWriter) Entry(entry model.StatementEntry) error {
+	var orderAccrual string
+	if entry.Order.Number != "" {
+		orderAccrual = strconv.Itoa(entry.Order.Data.Accrual)
+	}
+	s.csv.Write([]string{
+		entry.Entry.Data.Timestamp.Format(time.RFC3339),
+		entry.Entry.Key.Operation,
+		entry.Entry.Data.Kind,
+		entry.Entry.Data.Order,
+		entry.Order.Data.Status,
+		orderAccrual,
+		entry.Entry.Data.Reference,
+		strconv.Itoa(entry.Entry.Data.Difference),
+		strconv.Itoa(entry.Entry.Data.Balance),
+		strconv.Itoa(entry.Entry.Data.Withdrawn),
+	})
+	return s.csv.Error()
+}
+
+func (s *csvStatementWriter) Closing(totals service.StatementTotals) error {
+	err := s.totals(statementKindClosing, totals)
+	if err != nil {
+		return err
+	}
+	s.csv.Flush()
+	return s.csv.Error()
+}
+
+func (s *csvStatementWriter) totals(kind string, totals service.StatementTotals) error {
+	s.csv.Write([]string{"", "", kind, "", "", "", "", "",
+		strconv.Itoa(totals.Balance), strconv.Itoa(totals.Withdrawn)})
+	return s.csv.Error()
+}
+
+type StatementTotalsJSON struct {
+	Balance   int `json:"balance"`
+	Withdrawn int `json:"withdrawn"`
+}
+
+type StatementEntryJSON struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Operation    string    `json:"operation"`
+	Kind         string    `json:"kind"`
+	Order        string    `json:"order,omitempty"`
+	OrderStatus  string    `json:"order_status,omitempty"`
+	OrderAccrual *int      `json:"order_accrual,omitempty"`
+	Reference    string    `json:"reference,omitempty"`
+	Amount       int       `json:"amount"`
+	Balance      int       `json:"balance"`
+	Withdrawn    int       `json:"withdrawn"`
+}
+
+// jsonStatementWriter пишет объект
+// {"customer", "from", "to", "opening_balance", "entries": [...], "closing_balance"}
+// по мере получения операций
+type jsonStatementWriter struct {
+	w        http.ResponseWriter
+	customer string
+	from     time.Time
+	to       time.Time
+	filename string
+	begun    bool
+	entries  int
+}
+
+func (s *jsonStatementWriter) extension() string           { return statementFormatJSON }
+func (s *jsonStatementWriter) setFilename(filename string) { s.filename = filename }
+func (s *jsonStatementWriter) started() bool               { return s.begun }
+
+func (s *jsonStatementWriter) Opening(totals service.StatementTotals) error {
+	s.begun = true
+	statementHeader(s.w, "application/json", s.filename)
+	head := struct {
+		Customer string     `json:"customer"`
+		From     *time.Time `json:"from,omitempty"`
+		To       time.Time  `json:"to"`
+	}{Customer: s.customer, To: s.to}
+	if !s.from.IsZero() {
+		head.From = &s.from
+	}
+	headJSON, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	// открытый объект без закрывающей скобки, поля добавляются дальше
+	_, err = s.w.Write(headJSON[:len(headJSON)-1])
+	if err != nil {
+		return err
+	}
+	return s.field(`,"opening_balance":`, StatementTotalsJSON(totals), `,"entries":[`)
+}
+
+func (s *jsonStatementWriter) Entry(entry model.StatementEntry) error {
+	entryJSON := StatementEntryJSON{
+		Timestamp: entry.Entry.Data.Timestamp,
+		Operation: entry.Entry.Key.Operation,
+		Kind:      entry.Entry.Data.Kind,
+		Order:     entry.Entry.Data.Order,
+		Reference: entry.Entry.Data.Reference,
+		Amount:    entry.Entry.Data.Difference,
+		Balance:   entry.Entry.Data.Balance,
+		Withdrawn: entry.Entry.Data.Withdrawn,
+	}
+	if entry.Order.Number != "" {
+		entryJSON.OrderStatus = entry.Order.Data.Status
+		entryJSON.OrderAccrual = &entry.Order.Data.Accrual
+	}
+	prefix := ","
+	if s.entries == 0 {
+		prefix = ""
+	}
+	s.entries++
+	return s.field(prefix, entryJSON, "")
+}
+
+func (s *jsonStatementWriter) Closing(totals service.StatementTotals) error {
+	return s.field(`],"closing_balance":`, StatementTotalsJSON(totals), "}")
+}
+
+// field пишет значение в JSON между prefix и suffix
+func (s *jsonStatementWriter) field(prefix string, value any, suffix string) error {
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(s.w, prefix+string(valueJSON)+suffix)
+	return err
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/auth"
+	"github.com/iurnickita/gophermart/internal/pubsub"
+)
+
+// GetOrderStream передает изменения статусов и начислений заказов пользователя
+// в формате Server-Sent Events. Клиент может возобновить поток с заголовком Last-Event-ID;
+// если пропущенные события восстановить нельзя (напр. после перезапуска сервера),
+// передается текущее состояние всех заказов пользователя.
+func (h *handler) GetOrderStream(w http.ResponseWriter, r *http.Request) {
+	userCode := r.Header.Get(auth.UserCodeKey)
+
+	var lastEventID uint64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		var err error
+		lastEventID, err = strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			h.writeBadRequest(w, r, err)
+			return
+		}
+	}
+
+	backlog, events, cancel, err := h.service.SubscribeOrders(r.Context(), userCode, lastEventID)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	defer cancel()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range backlog {
+		if err := writeOrderEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.cfg.StreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			// комментарий SSE не виден клиенту, но держит соединение открытым
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				// подписка закрыта брокером - клиент переподключится с Last-Event-ID
+				return
+			}
+			if err := writeOrderEvent(w, event); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeOrderEvent(w http.ResponseWriter, event pubsub.OrderEvent) error {
+	data, err := json.Marshal(GetOrderJSONResponse{Number: event.Order.Number,
+		Status:      event.Order.Data.Status,
+		Accrual:     event.Order.Data.Accrual,
+		Uploaded_at: event.Order.Data.UploadedAt})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: order\ndata: %s\n\n", event.ID, data)
+	return err
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/handler/config"
+	"go.uber.org/zap"
+)
+
+const defaultTLSReloadInterval = time.Minute
+
+// Минимальные версии TLS. Версии ниже 1.2 не поддерживаются
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Наборы шифров, обязательные для HTTP/2 (RFC 7540, 9.2.2): без них net/http не запустит сервер
+var http2CipherSuites = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+}
+
+// newTLSConfig создает конфигурацию TLS сервера.
+// Сертификат перечитывается при изменении файлов, без перезапуска сервера, до отмены ctx
+func newTLSConfig(ctx context.Context, cfg config.Config, zaplog *zap.Logger) (*tls.Config, error) {
+	if cfg.TLSKeyFile == "" {
+		return nil, errors.New("tls: key file required")
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if cfg.TLSMinVersion != "" {
+		var ok bool
+		minVersion, ok = tlsVersions[cfg.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("tls: unsupported min version %q", cfg.TLSMinVersion)
+		}
+	}
+
+	// допускаются только наборы шифров, которые Go считает безопасными.
+	// Для TLS 1.3 набор шифров не настраивается
+	var cipherSuites []uint16
+	if len(cfg.TLSCipherSuites) > 0 {
+		secure := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			secure[suite.Name] = suite.ID
+		}
+		for _, name := range cfg.TLSCipherSuites {
+			id, ok := secure[name]
+			if !ok {
+				return nil, fmt.Errorf("tls: unknown or insecure cipher suite %q", name)
+			}
+			cipherSuites = append(cipherSuites, id)
+		}
+		// HTTP/2 с TLS включен всегда
+		if minVersion < tls.VersionTLS13 && !slices.ContainsFunc(cipherSuites, func(id uint16) bool {
+			return slices.Contains(http2CipherSuites, id)
+		}) {
+			return nil, errors.New("tls: cipher suites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" +
+				" or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 required by HTTP/2")
+		}
+	}
+
+	reloader, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, zaplog)
+	if err != nil {
+		return nil, err
+	}
+	interval := cfg.TLSReloadInterval
+	if interval <= 0 {
+		interval = defaultTLSReloadInterval
+	}
+	go reloader.watch(ctx, interval)
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	// mTLS для административного API: сертификат клиента проверяется, если он передан,
+	// а adminMiddleware требует его наличия
+	if cfg.AdminClientCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.AdminClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("tls: no certificates in admin client CA file")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+// certReloader хранит текущий сертификат сервера и перечитывает его при изменении файлов.
+// Ошибка чтения нового сертификата не прерывает работу: используется прежний
+type certReloader struct {
+	certFile string
+	keyFile  string
+	zaplog   *zap.Logger
+
+	mutex   sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile string, keyFile string, zaplog *zap.Logger) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile, zaplog: zaplog}
+	modTime, err := reloader.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	err = reloader.load(modTime)
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (reloader *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+	return reloader.cert, nil
+}
+
+func (reloader *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return err
+	}
+	reloader.mutex.Lock()
+	reloader.cert = &cert
+	reloader.modTime = modTime
+	reloader.mutex.Unlock()
+	return nil
+}
+
+// filesModTime - время последнего изменения сертификата или ключа
+func (reloader *certReloader) filesModTime() (time.Time, error) {
+	var modTime time.Time
+	for _, file := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+func (reloader *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		modTime, err := reloader.filesModTime()
+		if err != nil {
+			reloader.zaplog.Error("tls certificate check failed", zap.Error(err))
+			continue
+		}
+		reloader.mutex.RLock()
+		changed := !modTime.Equal(reloader.modTime)
+		reloader.mutex.RUnlock()
+		if !changed {
+			continue
+		}
+		err = reloader.load(modTime)
+		if err != nil {
+			// сертификат и ключ могут быть записаны не одновременно - повторим на следующем шаге
+			reloader.zaplog.Error("tls certificate reload failed", zap.Error(err))
+			continue
+		}
+		reloader.zaplog.Info("tls certificate reloaded", zap.String("file", reloader.certFile))
+	}
+}
+
+// redirectHandler перенаправляет HTTP-запросы на HTTPS-адрес сервера с сохранением метода и тела
+func redirectHandler(httpsAddr string) http.HandlerFunc {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	}
+}
@@ -2,4 +2,15 @@ package config
 
 type Config struct {
 	LogLevel string
+	// Уровни отдельных компонентов (handler, service, accrual, store), по умолчанию LogLevel
+	ComponentLevels map[string]string
+
+	// Файл журнала. Пустое значение - вывод в stderr
+	File string
+	// Ротация файла: максимальный размер в мегабайтах, количество и срок хранения (дней) старых файлов
+	FileMaxSize    int
+	FileMaxBackups int
+	FileMaxAge     int
+	// Сжимать ротированные файлы gzip
+	FileCompress bool
 }
@@ -0,0 +1,142 @@
+package logger
+
+import (
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Компоненты с отдельным уровнем логирования.
+// ComponentDefault - уровень остальных логеров (сверка, outbox и т.д.)
+const (
+	ComponentDefault = "default"
+	ComponentHandler = "handler"
+	ComponentService = "service"
+	ComponentAccrual = "accrual"
+	ComponentStore   = "store"
+	ComponentGRPC    = "grpc"
+)
+
+var components = []string{ComponentDefault, ComponentHandler, ComponentService, ComponentAccrual, ComponentStore, ComponentGRPC}
+
+var ErrUnknownComponent = errors.New("unknown log component")
+
+// Levels управляет уровнями логирования компонентов во время работы
+type Levels interface {
+	// Named возвращает логер компонента со своим уровнем
+	Named(component string) *zap.Logger
+	// Get возвращает текущие уровни всех компонентов
+	Get() map[string]string
+	// Set меняет уровень компонента
+	Set(component string, level string) error
+	// ToggleDebug переключает все компоненты в debug, повторный вызов возвращает прежние уровни.
+	// Возвращает true, если debug включен
+	ToggleDebug() bool
+}
+
+type levels struct {
+	// core пропускает записи всех уровней, фильтрацию делает levelCore компонента
+	core   zapcore.Core
+	opts   []zap.Option
+	levels map[string]zap.AtomicLevel
+
+	mutex sync.Mutex
+	// saved - уровни до включения debug через ToggleDebug
+	saved map[string]zapcore.Level
+}
+
+func newLevels(core zapcore.Core, opts []zap.Option, defaultLevel zapcore.Level, componentLevels map[string]string) (*levels, error) {
+	l := &levels{
+		core:   core,
+		opts:   opts,
+		levels: make(map[string]zap.AtomicLevel, len(components)),
+	}
+	for _, component := range components {
+		l.levels[component] = zap.NewAtomicLevelAt(defaultLevel)
+	}
+	for component, level := range componentLevels {
+		if err := l.Set(component, level); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *levels) Named(component string) *zap.Logger {
+	lvl, ok := l.levels[component]
+	if !ok {
+		lvl = l.levels[ComponentDefault]
+	}
+	zl := zap.New(&levelCore{Core: l.core, level: lvl}, l.opts...)
+	if component != ComponentDefault {
+		zl = zl.Named(component)
+	}
+	return zl
+}
+
+func (l *levels) Get() map[string]string {
+	result := make(map[string]string, len(l.levels))
+	for component, lvl := range l.levels {
+		result[component] = lvl.String()
+	}
+	return result
+}
+
+func (l *levels) Set(component string, level string) error {
+	lvl, ok := l.levels[component]
+	if !ok {
+		return ErrUnknownComponent
+	}
+	parsed, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	lvl.SetLevel(parsed)
+	return nil
+}
+
+func (l *levels) ToggleDebug() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.saved != nil {
+		for component, level := range l.saved {
+			l.levels[component].SetLevel(level)
+		}
+		l.saved = nil
+		return false
+	}
+	l.saved = make(map[string]zapcore.Level, len(l.levels))
+	for component, lvl := range l.levels {
+		l.saved[component] = lvl.Level()
+		lvl.SetLevel(zapcore.DebugLevel)
+	}
+	return true
+}
+
+// levelCore фильтрует записи по изменяемому уровню компонента
+type levelCore struct {
+	zapcore.Core
+	level zap.AtomicLevel
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level)
+}
+
+func (c *levelCore) Level() zapcore.Level {
+	return c.level.Level()
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(entry.Level) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
@@ -1,41 +1,111 @@
 package logger
 
 import (
+	"context"
 	"net/http"
-	"strconv"
+	"os"
 	"time"
 
 	"github.com/iurnickita/gophermart/internal/logger/config"
+	"github.com/iurnickita/gophermart/internal/problem"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-func NewZapLog(cfg config.Config) (*zap.Logger, error) {
-	// преобразуем текстовый уровень логирования в zap.AtomicLevel
-	lvl, err := zap.ParseAtomicLevel(cfg.LogLevel)
+// Ротация файла журнала по умолчанию
+const defaultFileMaxSize = 100
+
+// NewZapLog создает логер по умолчанию и управление уровнями компонентов.
+// Логеры компонентов создаются через Levels.Named
+func NewZapLog(cfg config.Config) (*zap.Logger, Levels, error) {
+	// преобразуем текстовый уровень логирования
+	lvl, err := zapcore.ParseLevel(cfg.LogLevel)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// вывод в stderr или в файл с ротацией
+	var output zapcore.WriteSyncer = zapcore.Lock(os.Stderr)
+	if cfg.File != "" {
+		if cfg.FileMaxSize <= 0 {
+			cfg.FileMaxSize = defaultFileMaxSize
+		}
+		output = zapcore.AddSync(&lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.FileMaxSize,
+			MaxBackups: cfg.FileMaxBackups,
+			MaxAge:     cfg.FileMaxAge,
+			Compress:   cfg.FileCompress,
+		})
 	}
-	// создаём новую конфигурацию логера
-	zapcfg := zap.NewProductionConfig()
-	// устанавливаем уровень
-	zapcfg.Level = lvl
-	// создаём логер на основе конфигурации
-	zl, err := zapcfg.Build()
+
+	// кодировщик как в zap.NewProductionConfig, но без сэмплирования: журнал доступа
+	// пишет одинаковые сообщения на каждый запрос, и сэмплер отбрасывал бы их под нагрузкой.
+	// Уровень ядра - debug, уровни компонентов применяются поверх него
+	encoderCfg := zap.NewProductionEncoderConfig()
+	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderCfg), output, zapcore.DebugLevel)
+
+	levels, err := newLevels(core, []zap.Option{zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)},
+		lvl, cfg.ComponentLevels)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	zl := levels.Named(ComponentDefault)
+
+	// логер по умолчанию для FromContext вне HTTP-запроса
+	zap.ReplaceGlobals(zl)
+
+	return zl, levels, nil
+}
+
+type ctxKey struct{}
+
+// requestLog - логер запроса, общий для всех middleware и обработчика
+type requestLog struct {
+	logger *zap.Logger
+}
+
+// FromContext возвращает логер запроса с полями request_id, trace_id и user.
+// Вне HTTP-запроса возвращается глобальный логер
+func FromContext(ctx context.Context) *zap.Logger {
+	if rl, ok := ctx.Value(ctxKey{}).(*requestLog); ok {
+		return rl.logger
+	}
+	return zap.L()
+}
+
+// NewContext кладет в контекст логер запроса, не пришедшего через RequestLogMdlw (напр. gRPC)
+func NewContext(ctx context.Context, zaplog *zap.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &requestLog{logger: zaplog})
+}
+
+// SetUser добавляет код пользователя в логер запроса и в запись журнала доступа
+func SetUser(ctx context.Context, user string) {
+	if rl, ok := ctx.Value(ctxKey{}).(*requestLog); ok {
+		rl.logger = rl.logger.With(zap.String("user", user))
 	}
-	//
-	return zl, nil
 }
 
 // middleware-логер для входящих HTTP-запросов.
+// Принимает идентификатор запроса из X-Request-ID или создает новый, возвращает его в ответе,
+// кладет в контекст логер запроса и пишет одну запись журнала доступа по завершении запроса
 func RequestLogMdlw(h http.HandlerFunc, zaplog *zap.Logger) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ValidRequestID(r.Header.Get(problem.RequestIDHeader)) {
+			r.Header.Del(problem.RequestIDHeader)
+		}
+		requestID := problem.RequestID(r)
+		w.Header().Set(problem.RequestIDHeader, requestID)
 
-		zaplog.Info("got incoming HTTP request",
-			zap.String("path", r.URL.Path),
-			zap.String("method", r.Method),
-		)
+		fields := []zap.Field{zap.String("request_id", requestID)}
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.HasTraceID() {
+			fields = append(fields, zap.String("trace_id", spanContext.TraceID().String()))
+		}
+		rl := &requestLog{logger: zaplog.With(fields...)}
+		r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, rl))
 
 		wl := NewResponseWriterLogger(w)
 
@@ -43,15 +113,41 @@ func RequestLogMdlw(h http.HandlerFunc, zaplog *zap.Logger) http.HandlerFunc {
 		h(wl, r)
 		handlerDuration := time.Since(handlerStart)
 
-		zaplog.Info("send HTTP response",
-			zap.String("code", strconv.Itoa(wl.statusCode)),
-			zap.String("length", strconv.Itoa(wl.length)),
-			zap.String("duration", handlerDuration.String()),
+		level := zapcore.InfoLevel
+		if wl.statusCode >= http.StatusInternalServerError {
+			level = zapcore.ErrorLevel
+		}
+		// user добавляется в rl.logger при авторизации (SetUser)
+		rl.logger.Log(level, "http request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("route", r.Pattern),
+			zap.Int("status", wl.statusCode),
+			zap.Int("bytes", wl.length),
+			zap.Duration("duration", handlerDuration),
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("user_agent", r.UserAgent()),
 		)
-
 	})
 }
 
+// ValidRequestID - идентификатор от клиента попадает в журнал и ответ,
+// поэтому принимаются только короткие строки из безопасных символов
+func ValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 128 {
+		return false
+	}
+	for _, c := range requestID {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 type responseWriterLogger struct {
 	http.ResponseWriter
 	statusCode int
@@ -67,6 +163,11 @@ func (wl *responseWriterLogger) WriteHeader(code int) {
 	wl.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap нужен http.ResponseController для доступа к Flush исходного http.ResponseWriter
+func (wl *responseWriterLogger) Unwrap() http.ResponseWriter {
+	return wl.ResponseWriter
+}
+
 func (wl *responseWriterLogger) Write(b []byte) (n int, err error) {
 	n, err = wl.ResponseWriter.Write(b)
 	wl.length += n
@@ -2,6 +2,59 @@ package model
 
 import "time"
 
+// Пользователи
+
+type Customer struct {
+	Code string
+	Data CustomerData
+}
+type CustomerData struct {
+	Login        string
+	PasswordHash string
+	ReferralCode string
+	CreatedAt    time.Time
+}
+
+// Отбор пользователей. Query - часть логина или точный код пользователя,
+// пустое значение не ограничивает выборку
+type CustomerFilter struct {
+	Query string
+	Limit int
+}
+
+// Администраторы. Токен выдается один раз при создании, хранится только его хеш
+
+type AdminUser struct {
+	Login string
+	Data  AdminUserData
+}
+type AdminUserData struct {
+	TokenHash string
+	CreatedAt time.Time
+}
+
+// Реферальная программа. Одна запись на приглашенного пользователя
+
+type Referral struct {
+	Referee string
+	Data    ReferralData
+}
+type ReferralData struct {
+	Referrer      string
+	CreatedAt     time.Time
+	RewardedAt    time.Time
+	ReferrerBonus int
+	RefereeBonus  int
+}
+
+// ReferralPolicy - условия выплаты бонусов по приглашению за первый обработанный заказ
+type ReferralPolicy struct {
+	ReferrerBonus         int
+	RefereeBonus          int
+	MaxRewardsPerReferrer int
+	MinOrderAccrual       int
+}
+
 // Входящие заказы
 
 type PurchaseOrder struct {
@@ -9,10 +62,12 @@ type PurchaseOrder struct {
 	Data   PurchaseOrderData
 }
 type PurchaseOrderData struct {
-	Customer   string
-	Status     string
-	Accrual    int
-	UploadedAt time.Time
+	Customer string
+	Status   string
+	Accrual  int
+	// Начисление системы начислений без множителя уровня
+	BaseAccrual int
+	UploadedAt  time.Time
 }
 
 const (
@@ -22,6 +77,14 @@ const (
 	PurchaseOrderStatusProcessed  = "PROCESSED"
 )
 
+// Заказ, ожидающий опроса системы начислений, и номер попытки
+type PollTask struct {
+	Order    PurchaseOrder
+	Attempts int
+	// Начало опроса: загрузка заказа или последнее возобновление опроса администратором
+	StartedAt time.Time
+}
+
 // Баланс и история
 
 type Balance struct {
@@ -34,8 +97,222 @@ type BalanceKey struct {
 }
 type BalanceData struct {
 	Timestamp  time.Time
+	Kind       string
 	Difference int
 	Balance    int
 	Withdrawn  int
 	Order      string
+	// Операция, на которую ссылается запись (например, отменяемое списание)
+	Reference string
+	// Списание отменено (заполняется только при выборке списаний)
+	Reversed bool
+	// Сумма активных холдов (заполняется только для актуального баланса)
+	OnHold int
+}
+
+// Запись выписки по счету: операция журнала и заказ, по которому начислены баллы
+type StatementEntry struct {
+	// Последняя операция до начала периода, ее итоги - входящий остаток
+	Opening bool
+	Entry   Balance
+	// Пустой, если операция не связана с загруженным заказом пользователя
+	Order PurchaseOrder
+}
+
+// Холды: баллы, зарезервированные до подтверждения списания
+
+type BalanceHold struct {
+	ID   string
+	Data BalanceHoldData
+}
+type BalanceHoldData struct {
+	Customer  string
+	Order     string
+	Points    int
+	Status    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// Операция списания, созданная при подтверждении
+	Operation string
+}
+
+const (
+	BalanceHoldStatusActive   = "ACTIVE"
+	BalanceHoldStatusCaptured = "CAPTURED"
+	BalanceHoldStatusReleased = "RELEASED"
+	BalanceHoldStatusExpired  = "EXPIRED"
+)
+
+// Виды операций журнала баланса
+const (
+	BalanceKindAccrual    = "ACCRUAL"
+	BalanceKindWithdrawal = "WITHDRAWAL"
+	BalanceKindCorrection = "CORRECTION"
+	BalanceKindReversal   = "REVERSAL"
+	BalanceKindBonus      = "BONUS"
+)
+
+// BalanceTotals применяет операцию журнала к нарастающим итогам баланса.
+// Списания увеличивают сумму списанного, отмены списаний уменьшают,
+// корректировки на нее не влияют.
+func BalanceTotals(balance int, withdrawn int, kind string, difference int) (int, int) {
+	balance += difference
+	switch kind {
+	case BalanceKindCorrection:
+	case BalanceKindWithdrawal, BalanceKindReversal:
+		withdrawn -= difference
+	default:
+		// записи без вида (до появления поля kind)
+		if difference < 0 {
+			withdrawn -= difference
+		}
+	}
+	return balance, withdrawn
+}
+
+// События предметной области (outbox)
+
+type Event struct {
+	ID       string
+	Attempts int
+	Data     EventData
+}
+type EventData struct {
+	Type      string
+	Customer  string
+	Order     string
+	Status    string
+	Points    int
+	CreatedAt time.Time
+}
+
+// Доставка события одному приемнику outbox
+type EventDelivery struct {
+	Event string
+	Sink  string
+	Data  EventDeliveryData
+}
+type EventDeliveryData struct {
+	Attempts      int
+	NextAttemptAt time.Time
+	// Пустое время - событие еще не доставлено / доставка не прекращена
+	DeliveredAt time.Time
+	DeadAt      time.Time
+	LastError   string
+}
+
+const (
+	EventOrderRegistered    = "OrderRegistered"
+	EventOrderStatusChanged = "OrderStatusChanged"
+	EventPointsAccrued      = "PointsAccrued"
+	EventPointsWithdrawn    = "PointsWithdrawn"
+	EventPointsReversed     = "PointsReversed"
+	EventBonusAccrued       = "BonusAccrued"
+)
+
+// Вебхуки пользователей
+
+type Webhook struct {
+	ID   string
+	Data WebhookData
+}
+type WebhookData struct {
+	Customer  string
+	URL       string
+	Secret    string
+	CreatedAt time.Time
+}
+
+// Журнал доставок вебхуков. Одна запись на каждую попытку
+type WebhookDelivery struct {
+	ID   string
+	Data WebhookDeliveryData
+}
+type WebhookDeliveryData struct {
+	Webhook    string
+	Event      string
+	Order      string
+	Attempt    int
+	StatusCode int
+	Error      string
+	Success    bool
+	Timestamp  time.Time
+}
+
+const (
+	WebhookEventOrderStatus = "order.status"
+	WebhookEventPing        = "ping"
+)
+
+// Уровни программы лояльности
+
+type CustomerTier struct {
+	Customer string
+	Data     CustomerTierData
+}
+type CustomerTierData struct {
+	Tier            string
+	LifetimeAccrued int
+	UpdatedAt       time.Time
+}
+
+// Журнал аудита безопасности. Записи только добавляются
+type AuditEvent struct {
+	ID   string
+	Data AuditEventData
+}
+type AuditEventData struct {
+	Timestamp time.Time
+	Action    string
+	// Кто выполнил действие: код пользователя, "admin", "admin:<логин>" или "system:<компонент>".
+	// Пустое значение - неавторизованный клиент
+	Actor string
+	IP    string
+	// Объект действия: логин, заказ, операция, холд
+	Target    string
+	Outcome   string
+	Detail    string
+	RequestID string
+}
+
+const (
+	AuditActionRegister      = "auth.register"
+	AuditActionLogin         = "auth.login"
+	AuditActionTokenRejected = "auth.token_rejected"
+	AuditActionWithdraw      = "balance.withdraw"
+	AuditActionCorrection    = "balance.correction"
+	AuditActionReversal      = "admin.withdrawal_reversal"
+	AuditActionHoldAuthorize = "admin.hold_authorize"
+	AuditActionHoldCapture   = "admin.hold_capture"
+	AuditActionHoldRelease   = "admin.hold_release"
+	AuditActionLogLevel      = "admin.log_level"
+	AuditActionAdminRejected = "admin.token_rejected"
+	AuditActionOrderRequeue  = "admin.order_requeue"
+	AuditActionOrderStatus   = "admin.order_status"
+	AuditActionAdminCreate   = "admin.user_create"
+)
+
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+	AuditOutcomeDenied  = "denied"
+)
+
+const (
+	AuditActorAdmin          = "admin"
+	AuditActorReconciliation = "system:reconciliation"
+	AuditActorCtl            = "system:gophermartctl"
+)
+
+// Отбор записей журнала аудита. Пустые поля не ограничивают выборку
+type AuditFilter struct {
+	Actor   string
+	Action  string
+	Target  string
+	Outcome string
+	From    time.Time
+	To      time.Time
+	// Записи с ID меньше заданного (постраничный просмотр от новых к старым)
+	BeforeID string
+	Limit    int
 }
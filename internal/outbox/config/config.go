@@ -0,0 +1,24 @@
+package config
+
+import "time"
+
+type Config struct {
+	// Период опроса таблицы outbox
+	Interval time.Duration
+	// Количество событий за один проход
+	BatchSize int
+	// После MaxAttempts неудачных попыток доставка приемнику прекращается (dead_at)
+	MaxAttempts int
+	// Время, на которое события закрепляются за экземпляром сервиса на время отправки.
+	// Должно быть больше SendTimeout: события, которые не успеют отправить до окончания аренды,
+	// освобождаются и отправляются следующим проходом
+	Lease time.Duration
+	// Время на отправку события во все приемники (приемники вызываются параллельно)
+	SendTimeout time.Duration
+	// Задержка перед первой повторной попыткой, далее удваивается
+	RetryBackoff time.Duration
+
+	// Приемники событий. Пустое значение - приемник отключен
+	WebhookURL string
+	FilePath   string
+}
@@ -0,0 +1,278 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/outbox/config"
+	"github.com/iurnickita/gophermart/internal/store"
+	"go.uber.org/zap"
+)
+
+// Диспетчер transactional outbox.
+// Периодически захватывает недоставленные события и отправляет их во все приемники.
+// Захват с арендой не дает нескольким экземплярам сервиса отправлять одни и те же события.
+// Доставка отслеживается по каждому приемнику: событие отправляется повторно только тем
+// приемникам, которые его не приняли (at-least-once: получатели должны отбрасывать дубликаты
+// по идентификатору события). После MaxAttempts неудачных попыток доставка приемнику
+// прекращается. Событие завершено, когда каждый приемник его принял или доставка ему прекращена.
+
+type Sink interface {
+	// Name - постоянное имя приемника, под ним хранится состояние доставки
+	Name() string
+	// Send отправляет событие. event.Attempts - число предыдущих попыток доставки этому приемнику
+	Send(ctx context.Context, event model.Event) error
+}
+
+type Dispatcher interface {
+	Run(ctx context.Context)
+}
+
+const (
+	defaultInterval     = time.Second
+	defaultBatchSize    = 100
+	defaultMaxAttempts  = 10
+	defaultLease        = 5 * time.Minute
+	defaultRetryBackoff = time.Second
+	defaultSendTimeout  = 10 * time.Second
+	maxRetryBackoff     = time.Hour
+)
+
+var errMaxAttempts = errors.New("max attempts reached")
+
+type dispatcher struct {
+	cfg    config.Config
+	store  store.Store
+	sinks  []Sink
+	zaplog *zap.Logger
+}
+
+// NewDispatcher создает диспетчер с приемниками из конфигурации и дополнительными sinks
+func NewDispatcher(cfg config.Config, store store.Store, zaplog *zap.Logger, sinks ...Sink) (Dispatcher, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = defaultLease
+	}
+	if cfg.SendTimeout <= 0 {
+		cfg.SendTimeout = defaultSendTimeout
+	}
+	if cfg.Lease <= cfg.SendTimeout {
+		return nil, fmt.Errorf("outbox lease %s must exceed send timeout %s", cfg.Lease, cfg.SendTimeout)
+	}
+
+	if cfg.WebhookURL != "" {
+		sinks = append(sinks, NewWebhookSink(cfg.WebhookURL))
+	}
+	if cfg.FilePath != "" {
+		sink, err := NewFileSink(cfg.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return &dispatcher{
+		cfg:    cfg,
+		store:  store,
+		sinks:  sinks,
+		zaplog: zaplog,
+	}, nil
+}
+
+func (d *dispatcher) Run(ctx context.Context) {
+	if len(d.sinks) == 0 {
+		// приемники не настроены - события копятся в outbox
+		return
+	}
+
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := d.dispatch(ctx)
+			if err != nil {
+				d.zaplog.Error("outbox dispatch failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (d *dispatcher) dispatch(ctx context.Context) error {
+	claimedAt := time.Now()
+	events, err := d.store.OutboxClaimPending(ctx, claimedAt, d.cfg.Lease, d.cfg.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for i, event := range events {
+		// отправка должна закончиться до окончания аренды, иначе событие захватит другой экземпляр.
+		// Оставшиеся события освобождаются и будут захвачены следующим проходом
+		if time.Since(claimedAt)+d.cfg.SendTimeout >= d.cfg.Lease {
+			for _, rest := range events[i:] {
+				err = d.store.OutboxRelease(ctx, rest.ID, time.Now())
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		err = d.deliver(ctx, event)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver отправляет событие приемникам, которые его еще не приняли и для которых подошло
+// время попытки. Приемники вызываются параллельно, все вместе не дольше SendTimeout
+func (d *dispatcher) deliver(ctx context.Context, event model.Event) error {
+	stored, err := d.store.OutboxDeliveryGet(ctx, event.ID)
+	if err != nil {
+		return err
+	}
+	deliveries := make(map[string]model.EventDelivery, len(d.sinks))
+	for _, delivery := range stored {
+		deliveries[delivery.Sink] = delivery
+	}
+
+	now := time.Now()
+	// sending[i].Sink пусто - приемнику i в этот раз не отправляется
+	sending := make([]model.EventDelivery, len(d.sinks))
+	errs := make([]error, len(d.sinks))
+	sendCtx, cancel := context.WithTimeout(ctx, d.cfg.SendTimeout)
+	var wg sync.WaitGroup
+	for i, sink := range d.sinks {
+		delivery, ok := deliveries[sink.Name()]
+		if !ok {
+			delivery = model.EventDelivery{Event: event.ID, Sink: sink.Name()}
+			// до учета доставки по приемникам попытки события были общими для всех приемников
+			if len(stored) == 0 {
+				delivery.Data.Attempts = event.Attempts
+			}
+		}
+		if !delivery.Data.DeliveredAt.IsZero() || !delivery.Data.DeadAt.IsZero() || delivery.Data.NextAttemptAt.After(now) {
+			deliveries[sink.Name()] = delivery
+			continue
+		}
+		sending[i] = delivery
+		// попытки могли быть исчерпаны при большем MaxAttempts
+		if delivery.Data.Attempts >= d.cfg.MaxAttempts {
+			errs[i] = errMaxAttempts
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sinkEvent := event
+			sinkEvent.Attempts = delivery.Data.Attempts
+			errs[i] = sink.Send(sendCtx, sinkEvent)
+		}()
+	}
+	wg.Wait()
+	cancel()
+
+	for i, delivery := range sending {
+		if delivery.Sink == "" {
+			continue
+		}
+		delivery = d.result(event, delivery, errs[i])
+		err = d.store.OutboxDeliveryPut(ctx, delivery)
+		if err != nil {
+			return err
+		}
+		deliveries[delivery.Sink] = delivery
+	}
+
+	// событие завершено, когда каждый приемник его принял или доставка ему прекращена
+	var nextAttemptAt time.Time
+	var lastError string
+	dead := false
+	for _, sink := range d.sinks {
+		delivery := deliveries[sink.Name()]
+		switch {
+		case !delivery.Data.DeadAt.IsZero():
+			dead = true
+			lastError = delivery.Data.LastError
+		case delivery.Data.DeliveredAt.IsZero():
+			lastError = delivery.Data.LastError
+			if nextAttemptAt.IsZero() || delivery.Data.NextAttemptAt.Before(nextAttemptAt) {
+				nextAttemptAt = delivery.Data.NextAttemptAt
+			}
+		}
+	}
+	switch {
+	case !nextAttemptAt.IsZero():
+		return d.store.OutboxMarkFailed(ctx, event.ID, nextAttemptAt, lastError)
+	case dead:
+		return d.store.OutboxMarkDead(ctx, event.ID, event.Attempts+1, lastError)
+	default:
+		return d.store.OutboxMarkDelivered(ctx, event.ID)
+	}
+}
+
+// result применяет к доставке результат попытки отправки
+func (d *dispatcher) result(event model.Event, delivery model.EventDelivery, sendErr error) model.EventDelivery {
+	now := time.Now()
+	if sendErr == nil {
+		delivery.Data.Attempts++
+		delivery.Data.DeliveredAt = now
+		return delivery
+	}
+	if sendErr != errMaxAttempts {
+		delivery.Data.Attempts++
+	}
+	delivery.Data.LastError = sendErr.Error()
+	if delivery.Data.Attempts >= d.cfg.MaxAttempts {
+		delivery.Data.DeadAt = now
+		d.zaplog.Error("outbox event delivery abandoned",
+			zap.String("id", event.ID),
+			zap.String("sink", delivery.Sink),
+			zap.String("type", event.Data.Type),
+			zap.String("customer", event.Data.Customer),
+			zap.String("order", event.Data.Order),
+			zap.Int("attempts", delivery.Data.Attempts),
+			zap.String("last_error", delivery.Data.LastError),
+		)
+		return delivery
+	}
+	delivery.Data.NextAttemptAt = now.Add(d.backoff(delivery.Data.Attempts - 1))
+	d.zaplog.Warn("outbox event delivery failed",
+		zap.String("id", event.ID),
+		zap.String("sink", delivery.Sink),
+		zap.String("type", event.Data.Type),
+		zap.Int("attempt", delivery.Data.Attempts),
+		zap.Error(sendErr),
+	)
+	return delivery
+}
+
+// backoff - экспоненциальная задержка перед следующей попыткой
+func (d *dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.cfg.RetryBackoff
+	for i := 0; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= maxRetryBackoff {
+			return maxRetryBackoff
+		}
+	}
+	return backoff
+}
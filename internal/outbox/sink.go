@@ -0,0 +1,102 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/iurnickita/gophermart/internal/model"
+)
+
+// JSON представление события для приемников
+type EventJSON struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Customer  string    `json:"customer"`
+	Order     string    `json:"order,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Points    int       `json:"points,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newEventJSON(event model.Event) EventJSON {
+	return EventJSON{
+		ID:        event.ID,
+		Type:      event.Data.Type,
+		Customer:  event.Data.Customer,
+		Order:     event.Data.Order,
+		Status:    event.Data.Status,
+		Points:    event.Data.Points,
+		CreatedAt: event.Data.CreatedAt,
+	}
+}
+
+// webhookSink отправляет событие POST-запросом на заданный URL.
+// Любой ответ, кроме 2xx, считается ошибкой доставки.
+type webhookSink struct {
+	url    string
+	client *resty.Client
+}
+
+func NewWebhookSink(url string) Sink {
+	return &webhookSink{
+		url:    url,
+		client: resty.New().SetTimeout(10 * time.Second),
+	}
+}
+
+func (sink *webhookSink) Name() string {
+	return "webhook"
+}
+
+func (sink *webhookSink) Send(ctx context.Context, event model.Event) error {
+	resp, err := sink.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Idempotency-Key", event.ID).
+		SetBody(newEventJSON(event)).
+		Post(sink.url)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() < http.StatusOK || resp.StatusCode() >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook response status: %d", resp.StatusCode())
+	}
+	return nil
+}
+
+// fileSink дописывает события в файл в формате NDJSON (одно событие на строку)
+type fileSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func NewFileSink(path string) (Sink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{file: file}, nil
+}
+
+func (sink *fileSink) Name() string {
+	return "file"
+}
+
+func (sink *fileSink) Send(_ context.Context, event model.Event) error {
+	line, err := json.Marshal(newEventJSON(event))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	_, err = sink.file.Write(line)
+	return err
+}
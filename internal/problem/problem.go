@@ -0,0 +1,96 @@
+package problem
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Ответы об ошибках в формате RFC 7807 (application/problem+json).
+// Code - стабильный машиночитаемый код ошибки, на него можно опираться в клиентах.
+// Detail не должен содержать внутренних подробностей (текстов ошибок БД и внешних систем).
+
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Коды ошибок
+const (
+	CodeBadRequest           = "bad_request"
+	CodeInsufficientData     = "insufficient_data"
+	CodeUnauthorized         = "unauthorized"
+	CodeInsufficientFunds    = "insufficient_funds"
+	CodeNotFound             = "not_found"
+	CodeAlreadyExists        = "already_exists"
+	CodeConflict             = "conflict"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeUnprocessableEntity  = "unprocessable_entity"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeInternal             = "internal_error"
+)
+
+const (
+	ContentType     = "application/problem+json"
+	RequestIDHeader = "X-Request-ID"
+)
+
+// Write отправляет ответ об ошибке
+func Write(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	requestID := RequestID(r)
+
+	responseJSON, err := json.Marshal(Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      code,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: requestID})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set(RequestIDHeader, requestID)
+	w.WriteHeader(status)
+	w.Write(responseJSON)
+}
+
+// WriteInternal отправляет ответ о внутренней ошибке без ее подробностей
+func WriteInternal(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, http.StatusInternalServerError, CodeInternal, "internal server error")
+}
+
+// WriteBadRequest отправляет ответ о некорректном теле запроса.
+// Превышение лимита размера тела (*http.MaxBytesError) - 413 Payload Too Large
+func WriteBadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		Write(w, r, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "request body too large")
+		return
+	}
+	Write(w, r, http.StatusBadRequest, CodeBadRequest, err.Error())
+}
+
+// RequestID возвращает идентификатор запроса из заголовка X-Request-ID.
+// Если клиент его не передал, идентификатор создается и сохраняется в заголовках запроса.
+func RequestID(r *http.Request) string {
+	requestID := r.Header.Get(RequestIDHeader)
+	if requestID == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		requestID = hex.EncodeToString(b)
+		r.Header.Set(RequestIDHeader, requestID)
+	}
+	return requestID
+}
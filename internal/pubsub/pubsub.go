@@ -0,0 +1,180 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+)
+
+// Внутрипроцессная шина изменений заказов.
+// Сервис публикует каждое изменение заказа, подписчики получают изменения заказов
+// своего пользователя. Для возобновления подписки по Last-Event-ID брокер хранит
+// последние backlogSize событий каждого пользователя. Backlog пользователя без подписчиков
+// удаляется через backlogTTL после последнего события, чтобы память не росла
+// с количеством пользователей. Идентификаторы событий монотонны и после перезапуска
+// продолжают расти: отсчет начинается с текущего времени в наносекундах. Если события после
+// Last-Event-ID восстановить нельзя (идентификатор выдан до перезапуска, события вытеснены
+// из backlog или удалены вместе с ним), подписчик получает текущее состояние заказов.
+
+type OrderEvent struct {
+	ID    uint64
+	Order model.PurchaseOrder
+}
+
+type Broker interface {
+	Publish(order model.PurchaseOrder)
+	Subscribe(customer string, lastEventID uint64) (backlog []OrderEvent, events <-chan OrderEvent, cancel func(), snapshotID uint64)
+}
+
+const (
+	defaultBacklogSize = 100
+	defaultBacklogTTL  = time.Hour
+	subscriberBuffer   = 16
+)
+
+// customerBacklog - последние события пользователя и время последнего события.
+// События с идентификатором не больше droppedID могли быть вытеснены
+type customerBacklog struct {
+	events    []OrderEvent
+	updatedAt time.Time
+	droppedID uint64
+}
+
+type broker struct {
+	mutex  sync.Mutex
+	lastID uint64
+	// startID - последний идентификатор до запуска процесса, sweptID - последний идентификатор
+	// на момент удаления чьего-либо backlog
+	startID     uint64
+	sweptID     uint64
+	backlogSize int
+	backlogTTL  time.Duration
+	backlog     map[string]*customerBacklog
+	subscribers map[string]map[chan OrderEvent]struct{}
+	// время последней очистки устаревших backlog
+	sweptAt time.Time
+}
+
+func NewBroker(backlogSize int, backlogTTL time.Duration) Broker {
+	if backlogSize <= 0 {
+		backlogSize = defaultBacklogSize
+	}
+	if backlogTTL <= 0 {
+		backlogTTL = defaultBacklogTTL
+	}
+	startID := uint64(time.Now().UnixNano())
+	return &broker{
+		lastID:      startID,
+		startID:     startID,
+		backlogSize: backlogSize,
+		backlogTTL:  backlogTTL,
+		backlog:     make(map[string]*customerBacklog),
+		subscribers: make(map[string]map[chan OrderEvent]struct{}),
+		sweptAt:     time.Now(),
+	}
+}
+
+func (b *broker) Publish(order model.PurchaseOrder) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	customer := order.Data.Customer
+	b.lastID++
+	event := OrderEvent{ID: b.lastID, Order: order}
+
+	now := time.Now()
+	b.sweep(now)
+	backlog, ok := b.backlog[customer]
+	if !ok {
+		backlog = &customerBacklog{droppedID: b.sweptID}
+		b.backlog[customer] = backlog
+	}
+	backlog.events = append(backlog.events, event)
+	if len(backlog.events) > b.backlogSize {
+		dropped := len(backlog.events) - b.backlogSize
+		backlog.droppedID = backlog.events[dropped-1].ID
+		backlog.events = backlog.events[dropped:]
+	}
+	backlog.updatedAt = now
+
+	for ch := range b.subscribers[customer] {
+		select {
+		case ch <- event:
+		default:
+			// подписчик не успевает читать - отключаем его,
+			// пропущенные события он получит из backlog при переподключении
+			delete(b.subscribers[customer], ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe возвращает события после lastEventID из backlog и канал новых событий.
+// Канал закрывается при вызове cancel или если подписчик не успевает читать события.
+// Если события после lastEventID восстановить нельзя, backlog пуст, а snapshotID - идентификатор
+// последнего события, с которым подписчику передается текущее состояние заказов
+func (b *broker) Subscribe(customer string, lastEventID uint64) ([]OrderEvent, <-chan OrderEvent, func(), uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var backlog []OrderEvent
+	var snapshotID uint64
+	if lastEventID > 0 {
+		if b.stale(customer, lastEventID) {
+			snapshotID = b.lastID
+		} else if b.backlog[customer] != nil {
+			for _, event := range b.backlog[customer].events {
+				if event.ID > lastEventID {
+					backlog = append(backlog, event)
+				}
+			}
+		}
+	}
+
+	ch := make(chan OrderEvent, subscriberBuffer)
+	if b.subscribers[customer] == nil {
+		b.subscribers[customer] = make(map[chan OrderEvent]struct{})
+	}
+	b.subscribers[customer][ch] = struct{}{}
+
+	cancel := func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, ok := b.subscribers[customer][ch]; ok {
+			delete(b.subscribers[customer], ch)
+			close(ch)
+		}
+		if len(b.subscribers[customer]) == 0 {
+			delete(b.subscribers, customer)
+		}
+	}
+	return backlog, ch, cancel, snapshotID
+}
+
+// stale - события после lastEventID могли быть потеряны. Вызывается под блокировкой
+func (b *broker) stale(customer string, lastEventID uint64) bool {
+	if lastEventID <= b.startID || lastEventID > b.lastID {
+		// идентификатор выдан до перезапуска или другим экземпляром сервиса
+		return true
+	}
+	if backlog, ok := b.backlog[customer]; ok {
+		return lastEventID < backlog.droppedID
+	}
+	return lastEventID < b.sweptID
+}
+
+// sweep удаляет backlog пользователей без подписчиков, не получавших событий дольше backlogTTL.
+// Выполняется не чаще одного раза за backlogTTL, вызывается под блокировкой
+func (b *broker) sweep(now time.Time) {
+	if now.Sub(b.sweptAt) < b.backlogTTL {
+		return
+	}
+	b.sweptAt = now
+	for customer, backlog := range b.backlog {
+		if now.Sub(backlog.updatedAt) >= b.backlogTTL && len(b.subscribers[customer]) == 0 {
+			delete(b.backlog, customer)
+			b.sweptID = b.lastID
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package config
+
+import "time"
+
+type Config struct {
+	Interval time.Duration
+	Repair   bool
+}
@@ -0,0 +1,271 @@
+package reconciliation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/audit"
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/reconciliation/config"
+	"github.com/iurnickita/gophermart/internal/store"
+	"go.uber.org/zap"
+)
+
+// Сверка баланса.
+// Журнал balance хранит нарастающие итоги в каждой записи, поэтому одна ошибочная запись
+// портит все последующие. Сверка заново проигрывает Difference каждой операции пользователя
+// и сравнивает результат с сохраненными итогами и с начислениями по заказам purchase_order.
+
+type Reconciler interface {
+	Reconcile(ctx context.Context, repair bool) (Report, error)
+	Run(ctx context.Context)
+}
+
+// Виды расхождений
+const (
+	MismatchBalance   = "balance"
+	MismatchWithdrawn = "withdrawn"
+	MismatchAccrual   = "accrual"
+)
+
+type Mismatch struct {
+	Customer  string `json:"customer"`
+	Kind      string `json:"kind"`
+	Operation string `json:"operation,omitempty"`
+	Order     string `json:"order,omitempty"`
+	Expected  int    `json:"expected"`
+	Actual    int    `json:"actual"`
+}
+
+type Report struct {
+	Customers  int
+	Mismatches []Mismatch
+	Repaired   int
+}
+
+const defaultInterval = time.Hour
+
+type reconciler struct {
+	cfg    config.Config
+	store  store.Store
+	audit  audit.Audit
+	zaplog *zap.Logger
+}
+
+func NewReconciler(cfg config.Config, store store.Store, audit audit.Audit, zaplog *zap.Logger) Reconciler {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	return &reconciler{
+		cfg:    cfg,
+		store:  store,
+		audit:  audit,
+		zaplog: zaplog,
+	}
+}
+
+// Run периодически выполняет сверку до отмены контекста
+func (rec *reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(rec.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := rec.Reconcile(ctx, rec.cfg.Repair)
+			if err != nil {
+				rec.zaplog.Error("balance reconciliation failed", zap.Error(err))
+				continue
+			}
+			for _, mismatch := range report.Mismatches {
+				rec.zaplog.Warn("balance mismatch",
+					zap.String("customer", mismatch.Customer),
+					zap.String("kind", mismatch.Kind),
+					zap.String("operation", mismatch.Operation),
+					zap.String("order", mismatch.Order),
+					zap.Int("expected", mismatch.Expected),
+					zap.Int("actual", mismatch.Actual),
+				)
+			}
+			rec.zaplog.Info("balance reconciliation finished",
+				zap.Int("customers", report.Customers),
+				zap.Int("mismatches", len(report.Mismatches)),
+				zap.Int("repaired", report.Repaired),
+			)
+		}
+	}
+}
+
+// Reconcile сверяет журналы всех пользователей.
+// При repair = true расхождения исправляются корректирующими записями журнала.
+func (rec *reconciler) Reconcile(ctx context.Context, repair bool) (Report, error) {
+	var report Report
+
+	customers, err := rec.store.BalanceGetCustomers(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	for _, customer := range customers {
+		mismatches, repaired, err := rec.reconcileCustomer(ctx, customer, repair)
+		if err != nil {
+			if err == store.ErrConcurrentUpdate {
+				// журнал изменился во время сверки - проверим при следующем запуске
+				continue
+			}
+			return report, err
+		}
+		report.Customers++
+		report.Mismatches = append(report.Mismatches, mismatches...)
+		report.Repaired += repaired
+	}
+
+	return report, nil
+}
+
+func (rec *reconciler) reconcileCustomer(ctx context.Context, customer string, repair bool) ([]Mismatch, int, error) {
+	// Журнал читается раньше заказов: если заказ обработан после чтения журнала,
+	// его начисление изменит последнюю операцию, и BalanceCorrect вернет ErrConcurrentUpdate
+	// вместо повторного начисления
+	history, err := rec.store.BalanceGetHistory(ctx, customer)
+	if err != nil {
+		return nil, 0, err
+	}
+	orders, err := rec.store.PurchaseOrderGet(ctx, customer)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var mismatches []Mismatch
+
+	// Проигрывание журнала.
+	// Итоги сверяются только после последней корректировки: корректировка фиксирует
+	// верные итоги, и более ранние расхождения уже исправлены.
+	lastCorrection := -1
+	for i, row := range history {
+		if row.Data.Kind == model.BalanceKindCorrection {
+			lastCorrection = i
+		}
+	}
+
+	var balance, withdrawn int
+	journalAccrual := make(map[string]int)
+	for i, row := range history {
+		balance, withdrawn = model.BalanceTotals(balance, withdrawn, row.Data.Kind, row.Data.Difference)
+
+		if row.Data.Order != "" && row.Data.Difference > 0 {
+			switch row.Data.Kind {
+			case model.BalanceKindAccrual, model.BalanceKindCorrection, "":
+				journalAccrual[row.Data.Order] += row.Data.Difference
+			}
+		}
+
+		if i < lastCorrection {
+			continue
+		}
+		if row.Data.Balance != balance {
+			mismatches = append(mismatches, Mismatch{
+				Customer:  customer,
+				Kind:      MismatchBalance,
+				Operation: row.Key.Operation,
+				Order:     row.Data.Order,
+				Expected:  balance,
+				Actual:    row.Data.Balance})
+		}
+		if row.Data.Withdrawn != withdrawn {
+			mismatches = append(mismatches, Mismatch{
+				Customer:  customer,
+				Kind:      MismatchWithdrawn,
+				Operation: row.Key.Operation,
+				Order:     row.Data.Order,
+				Expected:  withdrawn,
+				Actual:    row.Data.Withdrawn})
+		}
+	}
+	totalsDrift := len(mismatches) > 0
+
+	// Начисления по обработанным заказам должны совпадать с начислениями в журнале
+	var missing []Mismatch
+	for _, order := range orders {
+		if order.Data.Status != model.PurchaseOrderStatusProcessed {
+			continue
+		}
+		if journalAccrual[order.Number] != order.Data.Accrual {
+			mismatch := Mismatch{
+				Customer: customer,
+				Kind:     MismatchAccrual,
+				Order:    order.Number,
+				Expected: order.Data.Accrual,
+				Actual:   journalAccrual[order.Number]}
+			mismatches = append(mismatches, mismatch)
+			if mismatch.Expected > mismatch.Actual {
+				missing = append(missing, mismatch)
+			}
+		}
+	}
+
+	if !repair || (!totalsDrift && len(missing) == 0) {
+		return mismatches, 0, nil
+	}
+
+	// Исправление.
+	// Сначала итоги выравниваются по журналу, затем доначисляются недостающие баллы.
+	// Лишние начисления только попадают в отчет: списывать баллы автоматически нельзя.
+	repaired := 0
+	var lastOperation string
+	if len(history) > 0 {
+		lastOperation = history[len(history)-1].Key.Operation
+	}
+	if totalsDrift {
+		correction := model.Balance{
+			Key:  model.BalanceKey{Customer: customer},
+			Data: model.BalanceData{Balance: balance, Withdrawn: withdrawn}}
+		lastOperation, err = rec.store.BalanceCorrect(ctx, correction, lastOperation)
+		rec.recordCorrection(ctx, customer, lastOperation, err,
+			fmt.Sprintf("totals set to balance %d, withdrawn %d", balance, withdrawn))
+		if err != nil {
+			return mismatches, repaired, err
+		}
+		repaired++
+	}
+	for _, mismatch := range missing {
+		difference := mismatch.Expected - mismatch.Actual
+		balance, withdrawn = model.BalanceTotals(balance, withdrawn, model.BalanceKindCorrection, difference)
+		correction := model.Balance{
+			Key: model.BalanceKey{Customer: customer},
+			Data: model.BalanceData{
+				Difference: difference,
+				Balance:    balance,
+				Withdrawn:  withdrawn,
+				Order:      mismatch.Order}}
+		lastOperation, err = rec.store.BalanceCorrect(ctx, correction, lastOperation)
+		rec.recordCorrection(ctx, customer, lastOperation, err,
+			fmt.Sprintf("order %s accrual %+d", mismatch.Order, difference))
+		if err != nil {
+			return mismatches, repaired, err
+		}
+		repaired++
+	}
+
+	return mismatches, repaired, nil
+}
+
+// recordCorrection записывает корректировку баланса в журнал аудита
+func (rec *reconciler) recordCorrection(ctx context.Context, customer string, operation string, err error, detail string) {
+	outcome := model.AuditOutcomeSuccess
+	if err != nil {
+		outcome = model.AuditOutcomeFailure
+		detail += ": " + err.Error()
+	} else {
+		detail = "operation " + operation + ": " + detail
+	}
+	rec.audit.Record(ctx, model.AuditEvent{Data: model.AuditEventData{
+		Action:  model.AuditActionCorrection,
+		Actor:   model.AuditActorReconciliation,
+		Target:  customer,
+		Outcome: outcome,
+		Detail:  detail,
+	}})
+}
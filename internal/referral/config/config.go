@@ -0,0 +1,12 @@
+package config
+
+type Config struct {
+	// Бонус пригласившему, когда первый заказ приглашенного обработан
+	ReferrerBonus int
+	// Бонус приглашенному за первый обработанный заказ, 0 - не начисляется
+	RefereeBonus int
+	// Ограничение количества вознаграждаемых приглашений на пользователя
+	MaxRewardsPerReferrer int
+	// Минимальное начисление за первый заказ, при котором выплачивается бонус
+	MinOrderAccrual int
+}
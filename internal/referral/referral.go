@@ -0,0 +1,122 @@
+package referral
+
+import (
+	"context"
+	"errors"
+
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/referral/config"
+	"github.com/iurnickita/gophermart/internal/store"
+)
+
+// Реферальная программа.
+// Каждый пользователь получает реферальный код при регистрации. Новый пользователь может
+// указать код пригласившего; когда первый его заказ будет обработан, пригласившему
+// (и, если настроено, приглашенному) начисляются бонусные баллы.
+// Защита от злоупотреблений: код действует только при регистрации, бонус выплачивается
+// один раз на приглашенного, количество вознаграждаемых приглашений ограничено,
+// за заказ без начисления или с малым начислением бонус не выплачивается.
+
+type Referral interface {
+	Resolve(ctx context.Context, referralCode string) (model.Customer, error)
+	Policy() model.ReferralPolicy
+	Get(ctx context.Context, customer string) (Summary, error)
+}
+
+var (
+	ErrInvalidCode  = errors.New("invalid referral code")
+	ErrLimitReached = errors.New("referral limit reached")
+)
+
+const (
+	defaultReferrerBonus         = 100
+	defaultMaxRewardsPerReferrer = 50
+)
+
+// Summary - реферальный код пользователя и его приглашения
+type Summary struct {
+	Code       string
+	Referrals  []model.Referral
+	BonusTotal int
+}
+
+type referral struct {
+	cfg   config.Config
+	store store.Store
+}
+
+func NewReferral(cfg config.Config, store store.Store) Referral {
+	if cfg.ReferrerBonus <= 0 {
+		cfg.ReferrerBonus = defaultReferrerBonus
+	}
+	if cfg.MaxRewardsPerReferrer <= 0 {
+		cfg.MaxRewardsPerReferrer = defaultMaxRewardsPerReferrer
+	}
+	return &referral{
+		cfg:   cfg,
+		store: store,
+	}
+}
+
+// Resolve возвращает владельца реферального кода
+func (ref *referral) Resolve(ctx context.Context, referralCode string) (model.Customer, error) {
+	referrer, err := ref.store.CustomerGetByReferralCode(ctx, referralCode)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return model.Customer{}, ErrInvalidCode
+		}
+		return model.Customer{}, err
+	}
+
+	rewarded, err := ref.rewarded(ctx, referrer.Code)
+	if err != nil {
+		return model.Customer{}, err
+	}
+	if rewarded >= ref.cfg.MaxRewardsPerReferrer {
+		return model.Customer{}, ErrLimitReached
+	}
+	return referrer, nil
+}
+
+// Policy возвращает условия выплаты бонусов. Бонусы выплачиваются в транзакции,
+// переводящей первый заказ приглашенного в статус PROCESSED
+func (ref *referral) Policy() model.ReferralPolicy {
+	return model.ReferralPolicy{
+		ReferrerBonus:         ref.cfg.ReferrerBonus,
+		RefereeBonus:          ref.cfg.RefereeBonus,
+		MaxRewardsPerReferrer: ref.cfg.MaxRewardsPerReferrer,
+		MinOrderAccrual:       ref.cfg.MinOrderAccrual,
+	}
+}
+
+func (ref *referral) Get(ctx context.Context, customer string) (Summary, error) {
+	owner, err := ref.store.CustomerGet(ctx, customer)
+	if err != nil {
+		return Summary{}, err
+	}
+	referrals, err := ref.store.ReferralGet(ctx, customer)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	summary := Summary{Code: owner.Data.ReferralCode, Referrals: referrals}
+	for _, invite := range referrals {
+		summary.BonusTotal += invite.Data.ReferrerBonus
+	}
+	return summary, nil
+}
+
+// rewarded - количество вознагражденных приглашений пользователя
+func (ref *referral) rewarded(ctx context.Context, referrer string) (int, error) {
+	referrals, err := ref.store.ReferralGet(ctx, referrer)
+	if err != nil {
+		return 0, err
+	}
+	rewarded := 0
+	for _, invite := range referrals {
+		if !invite.Data.RewardedAt.IsZero() {
+			rewarded++
+		}
+	}
+	return rewarded, nil
+}
@@ -1,14 +1,26 @@
 package accrualclient
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/service/accrualclient/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
+var tracer = otel.Tracer("github.com/iurnickita/gophermart/internal/service/accrualclient")
+
 // JSON ответ accrual
 type AccrualAnswer struct {
 	Order   string `json:"order"`
@@ -24,27 +36,68 @@ const (
 )
 
 type AccrualClient interface {
-	GetAccrual(order model.PurchaseOrder) (AccrualAnswer, error)
+	GetAccrual(ctx context.Context, order model.PurchaseOrder) (AccrualAnswer, error)
+	Ping(ctx context.Context) error
+	// CircuitState - состояние автоматического выключателя: closed, open или half_open
+	CircuitState() string
+}
+
+// StatusError - система начислений ответила неожиданным HTTP-статусом
+type StatusError struct {
+	StatusCode int
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("accrual request status: %d", err.StatusCode)
 }
 
 type accrualClient struct {
-	serviceAddr string
+	client *resty.Client
+	zaplog *zap.Logger
 }
 
-func NewAccrualClient(serviceAddr string) AccrualClient {
-	return accrualClient{serviceAddr: serviceAddr}
+const defaultRequestTimeout = 10 * time.Second
+
+// NewAccrualClient создает клиент системы начислений. Все запросы идут через один
+// resty.Client (общий пул соединений), поверх него - выключатель с ограничением
+// параллельных запросов, объединение одновременных запросов и кэш конечных ответов
+func NewAccrualClient(serviceAddr string, cfg config.Config, zaplog *zap.Logger) AccrualClient {
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = defaultRequestTimeout
+	}
+	client := resty.New().
+		SetBaseURL(serviceAddr).
+		SetTimeout(cfg.RequestTimeout).
+		// транспорт otelhttp передает контекст трассировки в заголовке traceparent
+		SetTransport(otelhttp.NewTransport(http.DefaultTransport))
+	base := accrualClient{client: client, zaplog: zaplog}
+	return newCoalescer(newBreaker(base, cfg, zaplog), cfg)
 }
 
-func (client accrualClient) GetAccrual(order model.PurchaseOrder) (AccrualAnswer, error) {
+func (client accrualClient) GetAccrual(ctx context.Context, order model.PurchaseOrder) (AccrualAnswer, error) {
+	ctx, span := tracer.Start(ctx, "accrualclient.GetAccrual",
+		trace.WithAttributes(attribute.String("order.number", order.Number)))
+	defer span.End()
+
 	path := "/api/orders/"
 
-	setreq := resty.New().R()
+	setreq := client.client.R().SetContext(ctx)
 	setreq.Method = http.MethodGet
-	setreq.URL = client.serviceAddr + path + order.Number
+	setreq.URL = path + url.PathEscape(order.Number)
 	setresp, err := setreq.Send()
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		client.zaplog.Debug("accrual request failed", zap.String("order", order.Number), zap.Error(err))
 		return AccrualAnswer{}, err
 	}
+	span.SetAttributes(attribute.Int("http.response.status_code", setresp.StatusCode()))
+	client.zaplog.Debug("accrual response",
+		zap.String("order", order.Number),
+		zap.Int("status", setresp.StatusCode()),
+		zap.ByteString("body", setresp.Body()),
+		zap.Duration("duration", setresp.Time()),
+	)
 
 	switch setresp.StatusCode() {
 	case http.StatusOK:
@@ -52,6 +105,23 @@ func (client accrualClient) GetAccrual(order model.PurchaseOrder) (AccrualAnswer
 		err = json.Unmarshal(setresp.Body(), &accrualAnswer)
 		return accrualAnswer, err
 	default:
-		return AccrualAnswer{}, fmt.Errorf("accrual request status: %d", setresp.StatusCode())
+		return AccrualAnswer{}, &StatusError{StatusCode: setresp.StatusCode()}
 	}
 }
+
+// Ping проверяет доступность системы начислений.
+// Любой HTTP-ответ, кроме 5xx, означает, что сервис доступен
+func (client accrualClient) Ping(ctx context.Context) error {
+	setresp, err := client.client.R().SetContext(ctx).Get("/")
+	if err != nil {
+		return err
+	}
+	if setresp.StatusCode() >= http.StatusInternalServerError {
+		return &StatusError{StatusCode: setresp.StatusCode()}
+	}
+	return nil
+}
+
+func (client accrualClient) CircuitState() string {
+	return CircuitClosed
+}
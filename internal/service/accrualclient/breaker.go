@@ -0,0 +1,235 @@
+package accrualclient
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/service/accrualclient/config"
+	"go.uber.org/zap"
+)
+
+// Защита системы начислений и сервиса при ее недоступности:
+// автоматический выключатель прекращает запросы после серии ошибок и через OpenTimeout
+// пропускает пробные, ограничитель (bulkhead) не дает опросам заказов занять все соединения.
+
+// Состояния выключателя
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+var (
+	ErrCircuitOpen  = errors.New("accrual circuit breaker is open")
+	ErrBulkheadFull = errors.New("too many concurrent accrual requests")
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 30 * time.Second
+	defaultHalfOpenRequests = 1
+	defaultMaxConcurrent    = 10
+	defaultMaxWait          = time.Second
+)
+
+// Метрики клиента, доступны в /api/admin/metrics (expvar)
+var metrics = expvar.NewMap("accrual_client")
+
+type breaker struct {
+	client AccrualClient
+	cfg    config.Config
+	zaplog *zap.Logger
+
+	// свободные места bulkhead
+	slots chan struct{}
+
+	mutex    sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// пробные запросы в состоянии half_open: запущенные и успешные
+	trials    int
+	successes int
+}
+
+func newBreaker(client AccrualClient, cfg config.Config, zaplog *zap.Logger) *breaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultFailureThreshold
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = defaultOpenTimeout
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = defaultHalfOpenRequests
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = defaultMaxConcurrent
+	}
+	if cfg.MaxWait <= 0 {
+		cfg.MaxWait = defaultMaxWait
+	}
+	b := &breaker{
+		client: client,
+		cfg:    cfg,
+		zaplog: zaplog,
+		slots:  make(chan struct{}, cfg.MaxConcurrent),
+		state:  CircuitClosed,
+	}
+	metrics.Set("state", expvar.Func(func() any { return b.CircuitState() }))
+	metrics.Set("in_flight", expvar.Func(func() any { return len(b.slots) }))
+	return b
+}
+
+func (b *breaker) GetAccrual(ctx context.Context, order model.PurchaseOrder) (AccrualAnswer, error) {
+	if err := b.allow(); err != nil {
+		metrics.Add("rejected_open", 1)
+		return AccrualAnswer{}, err
+	}
+	if err := b.acquire(ctx); err != nil {
+		// запрос не выполнялся, пробный слот освобождается без изменения состояния
+		b.release()
+		return AccrualAnswer{}, err
+	}
+	defer func() { <-b.slots }()
+
+	metrics.Add("requests", 1)
+	answer, err := b.client.GetAccrual(ctx, order)
+	b.record(ctx, err)
+	return answer, err
+}
+
+// Ping проверяет систему начислений напрямую, в обход выключателя:
+// проверка готовности должна видеть фактическое состояние
+func (b *breaker) Ping(ctx context.Context) error {
+	return b.client.Ping(ctx)
+}
+
+func (b *breaker) CircuitState() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	// переход в half_open виден сразу, не дожидаясь следующего запроса
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.cfg.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// acquire занимает место в bulkhead, ожидая не дольше MaxWait
+func (b *breaker) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(b.cfg.MaxWait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		metrics.Add("rejected_bulkhead", 1)
+		return ErrBulkheadFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// allow решает, можно ли выполнить запрос в текущем состоянии
+func (b *breaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		b.setState(CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if b.trials >= b.cfg.HalfOpenRequests {
+			return ErrCircuitOpen
+		}
+		b.trials++
+	}
+	return nil
+}
+
+// release возвращает пробный слот запроса, который не был выполнен
+func (b *breaker) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == CircuitHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+// record учитывает результат запроса
+func (b *breaker) record(ctx context.Context, err error) {
+	failure := isOutage(err) && ctx.Err() == nil
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if failure {
+		metrics.Add("failures", 1)
+	}
+	switch b.state {
+	case CircuitClosed:
+		if !failure {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.setState(CircuitOpen)
+		}
+	case CircuitHalfOpen:
+		if failure {
+			b.setState(CircuitOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenRequests {
+			b.setState(CircuitClosed)
+		}
+	}
+}
+
+func (b *breaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	b.zaplog.Warn("accrual circuit breaker state changed",
+		zap.String("from", b.state), zap.String("to", state), zap.Int("failures", b.failures))
+	b.state = state
+	b.failures = 0
+	b.trials = 0
+	b.successes = 0
+	if state == CircuitOpen {
+		b.openedAt = time.Now()
+	}
+	metrics.Add("state_changes", 1)
+}
+
+// isOutage - ошибка говорит о недоступности или перегрузке системы начислений.
+// Ответы 2xx-4xx (кроме 429) означают, что система работает
+func isOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError ||
+			statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// IsTransient - после ошибки опрос заказа стоит повторить позже:
+// система начислений недоступна, перегружена или запрос отклонен выключателем
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrBulkheadFull) || isOutage(err)
+}
@@ -0,0 +1,165 @@
+package accrualclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/service/accrualclient/config"
+	"go.uber.org/zap"
+)
+
+// fakeClient возвращает заданную ошибку и считает выполненные запросы
+type fakeClient struct {
+	err   error
+	calls int
+}
+
+func (c *fakeClient) GetAccrual(ctx context.Context, order model.PurchaseOrder) (AccrualAnswer, error) {
+	c.calls++
+	return AccrualAnswer{}, c.err
+}
+
+func (c *fakeClient) Ping(ctx context.Context) error { return nil }
+
+func (c *fakeClient) CircuitState() string { return CircuitClosed }
+
+func TestBreaker(t *testing.T) {
+	errOutage := errors.New("connection refused")
+	errServer := &StatusError{StatusCode: http.StatusInternalServerError}
+	errTooMany := &StatusError{StatusCode: http.StatusTooManyRequests}
+	errNoContent := &StatusError{StatusCode: http.StatusNoContent}
+
+	const openTimeout = 20 * time.Millisecond
+
+	// шаг: ответ системы начислений, ожидаемая ошибка, выполнен ли запрос и состояние после него.
+	// wait - пауза перед шагом
+	type step struct {
+		wait      time.Duration
+		answer    error
+		wantErr   error
+		wantCall  bool
+		wantState string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens after threshold",
+			steps: []step{
+				{answer: errOutage, wantErr: errOutage, wantCall: true, wantState: CircuitClosed},
+				{answer: errServer, wantErr: errServer, wantCall: true, wantState: CircuitClosed},
+				{answer: errTooMany, wantErr: errTooMany, wantCall: true, wantState: CircuitOpen},
+				{answer: nil, wantErr: ErrCircuitOpen, wantCall: false, wantState: CircuitOpen},
+			},
+		},
+		{
+			name: "success resets failures",
+			steps: []step{
+				{answer: errOutage, wantErr: errOutage, wantCall: true, wantState: CircuitClosed},
+				{answer: errOutage, wantErr: errOutage, wantCall: true, wantState: CircuitClosed},
+				{answer: nil, wantErr: nil, wantCall: true, wantState: CircuitClosed},
+				{answer: errOutage, wantErr: errOutage, wantCall: true, wantState: CircuitClosed},
+				{answer: errOutage, wantErr: errOutage, wantCall: true, wantState: CircuitClosed},
+			},
+		},
+		{
+			name: "client errors are not outages",
+			steps: []step{
+				{answer: errNoContent, wantErr: errNoContent, wantCall: true, wantState: CircuitClosed},
+				{answer: errNoContent, wantErr: errNoContent, wantCall: true, wantState: CircuitClosed},
+				{answer: errNoContent, wantErr: errNoContent, wantCall: true, wantState: CircuitClosed},
+			},
+		},
+		{
+			name: "half open trial closes",
+			steps: []step{
+				{answer: errOutage, wantErr: errOutage, wantCall: true, wantState: CircuitClosed},
+				{answer: errOutage, wantErr: errOutage, wantCall: true, wantState: CircuitClosed},
+				{answer: errOutage, wantErr: errOutage, wantCall: true, wantState: CircuitOpen},
+				{wait: openTimeout, answer: nil, wantErr: nil, wantCall: true, wantState: CircuitClosed},
+				{answer: nil, wantErr: nil, wantCall: true, wantState: CircuitClosed},
+			},
+		},
+		{
+			name: "half open trial reopens",
+			steps: []step{
+				{answer: errOutage, wantErr: errOutage, wantCall: true, wantState: CircuitClosed},
+				{answer: errOutage, wantErr: errOutage, wantCall: true, wantState: CircuitClosed},
+				{answer: errOutage, wantErr: errOutage, wantCall: true, wantState: CircuitOpen},
+				{wait: openTimeout, answer: errServer, wantErr: errServer, wantCall: true, wantState: CircuitOpen},
+				{answer: nil, wantErr: ErrCircuitOpen, wantCall: false, wantState: CircuitOpen},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{}
+			b := newBreaker(client, config.Config{
+				FailureThreshold: 3,
+				OpenTimeout:      openTimeout,
+				HalfOpenRequests: 1}, zap.NewNop())
+			for i, s := range tt.steps {
+				time.Sleep(s.wait)
+				client.err = s.answer
+				calls := client.calls
+				_, err := b.GetAccrual(context.Background(), model.PurchaseOrder{})
+				if !errors.Is(err, s.wantErr) {
+					t.Fatalf("step %d: err = %v, want %v", i, err, s.wantErr)
+				}
+				if called := client.calls > calls; called != s.wantCall {
+					t.Fatalf("step %d: request sent = %v, want %v", i, called, s.wantCall)
+				}
+				if state := b.CircuitState(); state != s.wantState {
+					t.Fatalf("step %d: state = %s, want %s", i, state, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerHalfOpenLimitsTrials(t *testing.T) {
+	client := &fakeClient{err: errors.New("connection refused")}
+	b := newBreaker(client, config.Config{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Millisecond,
+		HalfOpenRequests: 1}, zap.NewNop())
+	_, _ = b.GetAccrual(context.Background(), model.PurchaseOrder{})
+	time.Sleep(2 * time.Millisecond)
+	if state := b.CircuitState(); state != CircuitHalfOpen {
+		t.Fatalf("state = %s, want %s", state, CircuitHalfOpen)
+	}
+
+	// пробный запрос занят, следующий отклоняется без обращения к системе начислений
+	if err := b.allow(); err != nil {
+		t.Fatalf("first trial: %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second trial: err = %v, want %v", err, ErrCircuitOpen)
+	}
+	// невыполненный запрос возвращает пробный слот
+	b.release()
+	if err := b.allow(); err != nil {
+		t.Fatalf("trial after release: %v", err)
+	}
+}
+
+func TestBreakerBulkhead(t *testing.T) {
+	b := newBreaker(&fakeClient{}, config.Config{
+		MaxConcurrent: 1,
+		MaxWait:       10 * time.Millisecond}, zap.NewNop())
+	if err := b.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if _, err := b.GetAccrual(context.Background(), model.PurchaseOrder{}); !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("err = %v, want %v", err, ErrBulkheadFull)
+	}
+	<-b.slots
+	if _, err := b.GetAccrual(context.Background(), model.PurchaseOrder{}); err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+}
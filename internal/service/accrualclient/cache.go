@@ -0,0 +1,125 @@
+package accrualclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/service/accrualclient/config"
+	"golang.org/x/sync/singleflight"
+)
+
+// Одновременные запросы по одному заказу (опрос, повторная постановка в очередь)
+// объединяются в один, конечные ответы кэшируются: они уже не изменятся.
+// Объединение и кэш действуют в пределах процесса.
+
+const (
+	defaultCacheTTL  = time.Minute
+	defaultCacheSize = 10000
+)
+
+type cachedAnswer struct {
+	answer    AccrualAnswer
+	expiresAt time.Time
+}
+
+type coalescer struct {
+	client AccrualClient
+	ttl    time.Duration
+	size   int
+
+	group singleflight.Group
+
+	mutex sync.Mutex
+	cache map[string]cachedAnswer
+}
+
+func newCoalescer(client AccrualClient, cfg config.Config) *coalescer {
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = defaultCacheTTL
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = defaultCacheSize
+	}
+	return &coalescer{
+		client: client,
+		ttl:    cfg.CacheTTL,
+		size:   cfg.CacheSize,
+		cache:  make(map[string]cachedAnswer),
+	}
+}
+
+func (c *coalescer) GetAccrual(ctx context.Context, order model.PurchaseOrder) (AccrualAnswer, error) {
+	if answer, ok := c.cached(order.Number); ok {
+		metrics.Add("cache_hits", 1)
+		return answer, nil
+	}
+
+	// общий запрос не отменяется вместе с первым вызывающим, его ограничивает таймаут клиента
+	result := c.group.DoChan(order.Number, func() (any, error) {
+		answer, err := c.client.GetAccrual(context.WithoutCancel(ctx), order)
+		if err == nil {
+			c.store(order.Number, answer)
+		}
+		return answer, err
+	})
+	select {
+	case <-ctx.Done():
+		return AccrualAnswer{}, ctx.Err()
+	case r := <-result:
+		if r.Shared {
+			metrics.Add("coalesced", 1)
+		}
+		if r.Err != nil {
+			return AccrualAnswer{}, r.Err
+		}
+		return r.Val.(AccrualAnswer), nil
+	}
+}
+
+func (c *coalescer) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx)
+}
+
+func (c *coalescer) CircuitState() string {
+	return c.client.CircuitState()
+}
+
+func (c *coalescer) cached(number string) (AccrualAnswer, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, ok := c.cache[number]
+	if !ok {
+		return AccrualAnswer{}, false
+	}
+	if time.Now().After(cached.expiresAt) {
+		delete(c.cache, number)
+		return AccrualAnswer{}, false
+	}
+	return cached.answer, true
+}
+
+// store кэширует конечный ответ. При заполненном кэше сначала удаляются устаревшие записи,
+// если места все равно нет - ответ не кэшируется
+func (c *coalescer) store(number string, answer AccrualAnswer) {
+	switch answer.Status {
+	case AccrualStatusInvalid, AccrualStatusProcessed:
+	default:
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	if len(c.cache) >= c.size {
+		for key, cached := range c.cache {
+			if now.After(cached.expiresAt) {
+				delete(c.cache, key)
+			}
+		}
+		if len(c.cache) >= c.size {
+			return
+		}
+	}
+	c.cache[number] = cachedAnswer{answer: answer, expiresAt: now.Add(c.ttl)}
+}
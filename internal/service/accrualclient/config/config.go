@@ -0,0 +1,24 @@
+package config
+
+import "time"
+
+type Config struct {
+	// Таймаут запроса к системе начислений
+	RequestTimeout time.Duration
+	// Время хранения конечных ответов (INVALID, PROCESSED) и максимальное количество заказов в кэше
+	CacheTTL  time.Duration
+	CacheSize int
+
+	// Автоматический выключатель (circuit breaker).
+	// Количество ошибок подряд, после которого запросы к системе начислений прекращаются
+	FailureThreshold int
+	// Время, через которое после размыкания пропускаются пробные запросы
+	OpenTimeout time.Duration
+	// Количество успешных пробных запросов для замыкания
+	HalfOpenRequests int
+
+	// Ограничение параллельных запросов (bulkhead)
+	MaxConcurrent int
+	// Максимальное ожидание свободного места, после него запрос отклоняется
+	MaxWait time.Duration
+}
@@ -1,5 +1,46 @@
 package config
 
+import (
+	"time"
+
+	accrualClientConfig "github.com/iurnickita/gophermart/internal/service/accrualclient/config"
+	tierConfig "github.com/iurnickita/gophermart/internal/tier/config"
+)
+
 type Config struct {
 	AccrualAddr string
+	// Выключатель и ограничение параллельных запросов к системе начислений
+	AccrualClient accrualClientConfig.Config
+	// Способ получения результатов расчета: опрос, push-уведомления или оба
+	AccrualMode string
+	// Опрос системы начислений (режимы poll и hybrid).
+	// Период проверки заказов, которые пора опросить, и сколько заказов берется за раз
+	PollInterval time.Duration
+	PollBatch    int
+	// Количество одновременно опрашиваемых заказов
+	PollWorkers int
+	// Время, на которое заказ закрепляется за экземпляром сервиса на время опроса
+	PollLease time.Duration
+	// Задержка повторного опроса (REGISTERED, PROCESSING или ошибка): PollBackoffBase * 2^попытка,
+	// не больше PollBackoffMax, со случайным отклонением ±PollBackoffJitter (доля от 0 до 1)
+	PollBackoffBase   time.Duration
+	PollBackoffMax    time.Duration
+	PollBackoffJitter float64
+	// Срок опроса заказа с загрузки или последнего возобновления, после которого опрос
+	// прекращается (dead letter). Возобновить опрос можно через POST /api/admin/orders/requeue
+	PollMaxAge time.Duration
+	// Количество последних изменений заказов пользователя, доступных для возобновления потока,
+	// и сколько они хранятся после последнего изменения
+	OrderEventsBacklog    int
+	OrderEventsBacklogTTL time.Duration
+	// Срок действия холда, если магазин не указал свой
+	HoldTTL time.Duration
+
+	Tier tierConfig.Config
 }
+
+const (
+	AccrualModePoll   = "poll"
+	AccrualModePush   = "push"
+	AccrualModeHybrid = "hybrid"
+)
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/store"
+)
+
+// Состояние зависимости
+const (
+	DependencyStatusUp   = "up"
+	DependencyStatusDown = "down"
+)
+
+// Зависимости сервиса
+const (
+	DependencyDatabase   = "database"
+	DependencyMigrations = "migrations"
+	DependencyAccrual    = "accrual"
+)
+
+type DependencyCheck struct {
+	Name    string
+	Status  string
+	Latency time.Duration
+	Error   string
+	// Состояние автоматического выключателя (только для системы начислений)
+	Circuit string
+}
+
+// CheckDependencies проверяет зависимости параллельно и возвращает результат по каждой.
+// Время проверки ограничивается контекстом
+func (service *service) CheckDependencies(ctx context.Context) []DependencyCheck {
+	checks := []struct {
+		name  string
+		check func(ctx context.Context) error
+	}{
+		{DependencyDatabase, service.store.Ping},
+		{DependencyMigrations, service.checkMigrations},
+		{DependencyAccrual, service.accrual.Ping},
+	}
+
+	results := make([]DependencyCheck, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := c.check(ctx)
+			results[i] = DependencyCheck{Name: c.name, Status: DependencyStatusUp, Latency: time.Since(start)}
+			if err != nil {
+				results[i].Status = DependencyStatusDown
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range results {
+		if results[i].Name == DependencyAccrual {
+			results[i].Circuit = service.accrual.CircuitState()
+		}
+	}
+	return results
+}
+
+func (service *service) checkMigrations(ctx context.Context) error {
+	version, err := service.store.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if expected := store.ExpectedSchemaVersion(); version != expected {
+		return fmt.Errorf("schema version %d, expected %d", version, expected)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package service
+
+// Максимальная длина номера заказа (размер колонок номера заказа в БД)
+const maxOrderNumberLength = 32
+
+// orderNumberValid проверяет длину номера заказа и контрольную цифру
+func orderNumberValid(number string) bool {
+	return len(number) <= maxOrderNumberLength && luhnValid(number)
+}
+
+// luhnValid проверяет номер заказа по алгоритму Луна
+func luhnValid(number string) bool {
+	if number == "" {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		digit := int(number[i] - '0')
+		if digit < 0 || digit > 9 {
+			return false
+		}
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		want   bool
+	}{
+		{name: "valid", number: "12345678903", want: true},
+		{name: "valid card", number: "4561261212345467", want: true},
+		{name: "single zero", number: "0", want: true},
+		{name: "wrong check digit", number: "12345678904", want: false},
+		{name: "swapped digits", number: "4561261212345476", want: false},
+		{name: "empty", number: "", want: false},
+		{name: "letters", number: "1234a678903", want: false},
+		{name: "spaces", number: "1234 5678 903", want: false},
+		{name: "sign", number: "-12345678903", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := luhnValid(tt.number); got != tt.want {
+				t.Errorf("luhnValid(%q) = %v, want %v", tt.number, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderNumberValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		want   bool
+	}{
+		{name: "valid", number: "12345678903", want: true},
+		{name: "max length", number: strings.Repeat("0", maxOrderNumberLength), want: true},
+		{name: "too long", number: strings.Repeat("0", maxOrderNumberLength+1), want: false},
+		{name: "wrong check digit", number: "12345678904", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orderNumberValid(tt.number); got != tt.want {
+				t.Errorf("orderNumberValid(%q) = %v, want %v", tt.number, got, tt.want)
+			}
+		})
+	}
+}
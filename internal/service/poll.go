@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/service/accrualclient"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Опрос системы начислений по расписанию.
+// Для каждого необработанного заказа в БД хранятся время следующего опроса и число попыток.
+// Пока система начислений отвечает REGISTERED/PROCESSING или недоступна, интервал между
+// опросами растет экспоненциально; заказ, опрашиваемый дольше PollMaxAge с загрузки или
+// последнего возобновления опроса, больше не опрашивается (dead letter).
+
+const (
+	defaultPollInterval      = time.Second
+	defaultPollBatch         = 100
+	defaultPollWorkers       = 10
+	defaultPollLease         = time.Minute
+	defaultPollBackoffBase   = 5 * time.Second
+	defaultPollBackoffMax    = 10 * time.Minute
+	defaultPollBackoffJitter = 0.2
+	defaultPollMaxAge        = 24 * time.Hour
+)
+
+// pollScheduler периодически забирает заказы, которые пора опросить, и опрашивает их
+// не более PollWorkers одновременно
+func (service *service) pollScheduler(ctx context.Context) {
+	cfg := service.cfg
+	ticker := time.NewTicker(cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// пока заказы есть, следующая порция забирается сразу
+		for {
+			tasks, err := service.store.PurchaseOrderClaimDue(ctx, time.Now(), cfg.PollLease, cfg.PollBatch)
+			if err != nil {
+				service.zaplog.Error("accrual poll schedule failed", zap.Error(err))
+				break
+			}
+			service.pollTasks(ctx, tasks)
+			if len(tasks) < cfg.PollBatch {
+				break
+			}
+		}
+	}
+}
+
+func (service *service) pollTasks(ctx context.Context, tasks []model.PollTask) {
+	workers := make(chan struct{}, service.cfg.PollWorkers)
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			service.pollOrder(ctx, task)
+		}()
+	}
+	wg.Wait()
+}
+
+// pollOrder опрашивает систему начислений по заказу и назначает следующий опрос
+func (service *service) pollOrder(ctx context.Context, task model.PollTask) {
+	order := task.Order
+	// отдельная трасса на каждый опрос
+	ctx, span := tracer.Start(ctx, "service.accrualPoll",
+		trace.WithAttributes(attribute.String("order.number", order.Number),
+			attribute.Int("poll.attempts", task.Attempts)))
+	defer span.End()
+
+	final := false
+	accrualAnswer, err := service.accrual.GetAccrual(ctx, order)
+	if err == nil {
+		accrualAnswer.Order = order.Number
+		final, err = service.applyAccrual(ctx, accrualAnswer)
+		if err != nil {
+			service.zaplog.Error("accrual apply failed",
+				zap.String("order", order.Number), zap.Error(err))
+		} else {
+			service.zaplog.Debug("accrual polled",
+				zap.String("order", order.Number),
+				zap.String("status", accrualAnswer.Status),
+				zap.Int("accrual", accrualAnswer.Accrual),
+				zap.Bool("final", final))
+		}
+	} else if accrualclient.IsTransient(err) {
+		// выключатель отклоняет запросы без обращения к системе начислений, пока она не восстановится
+		service.zaplog.Debug("accrual poll failed, will retry",
+			zap.String("order", order.Number), zap.Error(err))
+	} else {
+		service.zaplog.Warn("accrual poll failed, will retry",
+			zap.String("order", order.Number), zap.Error(err))
+	}
+
+	attempts := task.Attempts + 1
+	now := time.Now()
+	switch {
+	case final:
+		err = service.store.PurchaseOrderSchedule(ctx, order.Number, attempts, time.Time{})
+	case now.Sub(task.StartedAt) >= service.cfg.PollMaxAge:
+		service.zaplog.Warn("accrual polling gave up, order moved to dead letter",
+			zap.String("order", order.Number),
+			zap.Int("attempts", attempts),
+			zap.Time("uploaded_at", order.Data.UploadedAt),
+			zap.Time("poll_started_at", task.StartedAt))
+		err = service.store.PurchaseOrderDeadLetter(ctx, order.Number, attempts, now)
+	default:
+		err = service.store.PurchaseOrderSchedule(ctx, order.Number, attempts, now.Add(service.pollBackoff(attempts)))
+	}
+	if err != nil {
+		// по истечении аренды заказ будет опрошен снова
+		service.zaplog.Error("accrual poll reschedule failed",
+			zap.String("order", order.Number), zap.Error(err))
+	}
+}
+
+// pollBackoff - задержка перед следующим опросом после attempts попыток:
+// PollBackoffBase * 2^(attempts-1), не больше PollBackoffMax, ±PollBackoffJitter.
+// Случайное отклонение разносит опросы заказов, загруженных одновременно
+func (service *service) pollBackoff(attempts int) time.Duration {
+	delay := service.cfg.PollBackoffBase
+	for i := 1; i < attempts && delay < service.cfg.PollBackoffMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, service.cfg.PollBackoffMax)
+	jitter := service.cfg.PollBackoffJitter * (2*rand.Float64() - 1)
+	return time.Duration(float64(delay) * (1 + jitter))
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/service/config"
+)
+
+func TestPollBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		jitter   float64
+		attempts int
+		want     time.Duration
+	}{
+		{name: "first attempt", attempts: 1, want: time.Second},
+		{name: "zero attempts", attempts: 0, want: time.Second},
+		{name: "doubling", attempts: 3, want: 4 * time.Second},
+		{name: "capped", attempts: 10, want: 30 * time.Second},
+		{name: "many attempts", attempts: 1000, want: 30 * time.Second},
+		{name: "jitter", jitter: 0.2, attempts: 2, want: 2 * time.Second},
+		{name: "jitter capped", jitter: 0.2, attempts: 10, want: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &service{cfg: config.Config{
+				PollBackoffBase:   time.Second,
+				PollBackoffMax:    30 * time.Second,
+				PollBackoffJitter: tt.jitter}}
+			low := time.Duration(float64(tt.want) * (1 - tt.jitter))
+			high := time.Duration(float64(tt.want) * (1 + tt.jitter))
+			// отклонение случайное, поэтому проверяется диапазон на нескольких вызовах
+			for range 100 {
+				got := service.pollBackoff(tt.attempts)
+				if got < low || got > high {
+					t.Fatalf("pollBackoff(%d) = %v, want in [%v, %v]", tt.attempts, got, low, high)
+				}
+			}
+		})
+	}
+}
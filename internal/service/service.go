@@ -7,17 +7,45 @@ import (
 
 	"github.com/iurnickita/gophermart/internal/balance"
 	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/pubsub"
+	"github.com/iurnickita/gophermart/internal/referral"
 	"github.com/iurnickita/gophermart/internal/service/accrualclient"
 	"github.com/iurnickita/gophermart/internal/service/config"
 	"github.com/iurnickita/gophermart/internal/store"
+	"github.com/iurnickita/gophermart/internal/tier"
+	"github.com/iurnickita/gophermart/internal/webhook"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
+var tracer = otel.Tracer("github.com/iurnickita/gophermart/internal/service")
+
 type Service interface {
-	PostOrder(order model.PurchaseOrder) error
-	GetOrder(customer string) ([]model.PurchaseOrder, error)
-	GetBalance(customer string) (model.Balance, error)
-	PostWithdraw(order model.PurchaseOrder, points int) error
-	GetWithdrawals(customer string) ([]model.Balance, error)
+	CheckDependencies(ctx context.Context) []DependencyCheck
+	PostOrder(ctx context.Context, order model.PurchaseOrder) error
+	GetOrder(ctx context.Context, customer string) ([]model.PurchaseOrder, error)
+	GetBalance(ctx context.Context, customer string) (model.Balance, error)
+	PostOrderBatch(ctx context.Context, customer string, numbers []string) ([]OrderBatchResult, error)
+	PostWithdraw(ctx context.Context, order model.PurchaseOrder, points int) error
+	GetWithdrawals(ctx context.Context, customer string) ([]model.Balance, error)
+	GetStatement(ctx context.Context, customer string, from time.Time, to time.Time, w StatementWriter) error
+	GetProfile(ctx context.Context, customer string) (Profile, error)
+	GetReferrals(ctx context.Context, customer string) (referral.Summary, error)
+	RequeueOrders(ctx context.Context, olderThan time.Duration) ([]model.PurchaseOrder, error)
+	ForceOrderStatus(ctx context.Context, number string, status string, accrual int) (model.PurchaseOrder, error)
+	ReverseWithdrawal(ctx context.Context, customer string, operation string) (model.Balance, error)
+	AuthorizeWithdrawal(ctx context.Context, order model.PurchaseOrder, points int, ttl time.Duration) (model.BalanceHold, error)
+	CaptureWithdrawal(ctx context.Context, hold string) (model.Balance, error)
+	ReleaseWithdrawal(ctx context.Context, hold string) error
+	PostWebhook(ctx context.Context, customer string, url string) (model.Webhook, error)
+	GetWebhooks(ctx context.Context, customer string) ([]model.Webhook, error)
+	DeleteWebhook(ctx context.Context, customer string, id string) error
+	GetWebhookDeliveries(ctx context.Context, customer string, id string) ([]model.WebhookDelivery, error)
+	PingWebhook(ctx context.Context, customer string, id string) (model.WebhookDelivery, error)
+	PostAccrual(ctx context.Context, accrualAnswer accrualclient.AccrualAnswer) error
+	SubscribeOrders(ctx context.Context, customer string, lastEventID uint64) ([]pubsub.OrderEvent, <-chan pubsub.OrderEvent, func(), error)
 }
 
 var (
@@ -26,30 +54,79 @@ var (
 	ErrAlreadyExists       = errors.New("already exists")
 	ErrDuplicateRequest    = errors.New("duplicate request")
 	ErrInsufficientFunds   = errors.New("insufficient funds")
+	ErrNotFound            = errors.New("not found")
+	ErrConflict            = errors.New("conflict")
 )
 
 type service struct {
-	cfg     config.Config
-	store   store.Store
-	balance balance.Balance
-	accrual accrualclient.AccrualClient
+	cfg      config.Config
+	store    store.Store
+	balance  balance.Balance
+	accrual  accrualclient.AccrualClient
+	webhook  webhook.Webhook
+	broker   pubsub.Broker
+	tier     tier.Tier
+	referral referral.Referral
+	zaplog   *zap.Logger
 }
 
-func NewService(cfg config.Config, store store.Store) Service {
+func NewService(cfg config.Config, store store.Store, referral referral.Referral, webhook webhook.Webhook, accrual accrualclient.AccrualClient, zaplog *zap.Logger) Service {
+	if cfg.AccrualMode == "" {
+		cfg.AccrualMode = config.AccrualModePoll
+	}
+	if cfg.HoldTTL <= 0 {
+		cfg.HoldTTL = defaultHoldTTL
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.PollBatch <= 0 {
+		cfg.PollBatch = defaultPollBatch
+	}
+	if cfg.PollWorkers <= 0 {
+		cfg.PollWorkers = defaultPollWorkers
+	}
+	if cfg.PollLease <= 0 {
+		cfg.PollLease = defaultPollLease
+	}
+	if cfg.PollBackoffBase <= 0 {
+		cfg.PollBackoffBase = defaultPollBackoffBase
+	}
+	if cfg.PollBackoffMax <= 0 {
+		cfg.PollBackoffMax = defaultPollBackoffMax
+	}
+	if cfg.PollBackoffJitter <= 0 || cfg.PollBackoffJitter > 1 {
+		cfg.PollBackoffJitter = defaultPollBackoffJitter
+	}
+	if cfg.PollMaxAge <= 0 {
+		cfg.PollMaxAge = defaultPollMaxAge
+	}
 	balance := balance.NewBalance(store)
-	accrual := accrualclient.NewAccrualClient(cfg.AccrualAddr)
 
 	service := service{
-		cfg:     cfg,
-		store:   store,
-		balance: balance,
-		accrual: accrual}
+		cfg:      cfg,
+		store:    store,
+		balance:  balance,
+		accrual:  accrual,
+		webhook:  webhook,
+		broker:   pubsub.NewBroker(cfg.OrderEventsBacklog, cfg.OrderEventsBacklogTTL),
+		tier:     tier.NewTier(cfg.Tier, store, zaplog),
+		referral: referral,
+		zaplog:   zaplog}
+
+	go service.holdExpiry()
+	// в push-режиме результат придет от системы начислений, опрос не нужен
+	if cfg.AccrualMode != config.AccrualModePush {
+		go service.pollScheduler(context.Background())
+	}
+	go service.tier.Run(context.Background())
 
 	return &service
 }
 
-func (service *service) PostOrder(order model.PurchaseOrder) error {
-	ctx := context.Background()
+func (service *service) PostOrder(ctx context.Context, order model.PurchaseOrder) error {
+	ctx, span := tracer.Start(ctx, "service.PostOrder")
+	defer span.End()
 
 	if order.Number == "" {
 		return ErrInsufficientData
@@ -57,8 +134,10 @@ func (service *service) PostOrder(order model.PurchaseOrder) error {
 	if order.Data.Customer == "" {
 		return ErrInsufficientData
 	}
-	// Проверка по алгоритму Луна
-	// ... ErrUnprocessableEntity
+	// Проверка длины и по алгоритму Луна
+	if !orderNumberValid(order.Number) {
+		return ErrUnprocessableEntity
+	}
 
 	var newOrder model.PurchaseOrder
 	newOrder.Number = order.Number
@@ -78,52 +157,250 @@ func (service *service) PostOrder(order model.PurchaseOrder) error {
 		}
 	}
 
-	go service.accrualProcessing(newOrder)
+	// опрос системы начислений запустит планировщик (pollScheduler)
+	return nil
+}
+
+// Результаты загрузки номера заказа в пакете
+const (
+	OrderBatchAccepted        = "accepted"
+	OrderBatchAlreadyUploaded = "already_uploaded"
+	OrderBatchConflict        = "owned_by_another_user"
+	OrderBatchInvalid         = "invalid"
+)
+
+// Максимальное количество номеров в одном пакете
+const maxOrderBatch = 1000
+
+type OrderBatchResult struct {
+	Number string
+	Result string
+}
+
+// PostOrderBatch загружает пакет номеров заказов в одной транзакции
+// и возвращает результат по каждому номеру в исходном порядке
+func (service *service) PostOrderBatch(ctx context.Context, customer string, numbers []string) ([]OrderBatchResult, error) {
+	ctx, span := tracer.Start(ctx, "service.PostOrderBatch")
+	defer span.End()
+
+	if customer == "" || len(numbers) == 0 {
+		return nil, ErrInsufficientData
+	}
+	if len(numbers) > maxOrderBatch {
+		return nil, ErrUnprocessableEntity
+	}
+
+	results := make([]OrderBatchResult, len(numbers))
+	var newOrders []model.PurchaseOrder
+	var newOrderIdx []int
+	seen := make(map[string]bool)
+	uploadedAt := time.Now()
+	for i, number := range numbers {
+		results[i].Number = number
+		switch {
+		case !orderNumberValid(number):
+			// в том числе слишком длинный номер: ошибка записи откатила бы весь пакет
+			results[i].Result = OrderBatchInvalid
+		case seen[number]:
+			// повтор внутри пакета
+			results[i].Result = OrderBatchAlreadyUploaded
+		default:
+			seen[number] = true
+			newOrders = append(newOrders, model.PurchaseOrder{
+				Number: number,
+				Data: model.PurchaseOrderData{
+					Customer:   customer,
+					Status:     model.PurchaseOrderStatusNew,
+					UploadedAt: uploadedAt}})
+			newOrderIdx = append(newOrderIdx, i)
+		}
+	}
+	if len(newOrders) == 0 {
+		return results, nil
+	}
+
+	errs, err := service.store.PurchaseOrderPostBatch(ctx, newOrders)
+	if err != nil {
+		return nil, err
+	}
+	for i, err := range errs {
+		result := &results[newOrderIdx[i]]
+		switch err {
+		case nil:
+			result.Result = OrderBatchAccepted
+		case store.ErrDuplicateRequest:
+			result.Result = OrderBatchAlreadyUploaded
+		default:
+			result.Result = OrderBatchConflict
+		}
+	}
+
+	return results, nil
+}
+
+// applyAccrual применяет ответ системы начислений к заказу.
+// Возвращает true, если заказ в конечном статусе и опрос можно прекратить.
+// Конечный статус и начисление записываются одной транзакцией и только для необработанного
+// заказа, поэтому результаты опроса и push-уведомления можно применять в любом порядке,
+// повторно и одновременно на нескольких экземплярах сервиса.
+func (service *service) applyAccrual(ctx context.Context, accrualAnswer accrualclient.AccrualAnswer) (bool, error) {
+	order, err := service.store.PurchaseOrderGetByNumber(ctx, accrualAnswer.Order)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return true, ErrNotFound
+		}
+		return false, err
+	}
+	switch order.Data.Status {
+	case model.PurchaseOrderStatusInvalid, model.PurchaseOrderStatusProcessed:
+		return true, nil
+	}
+
+	switch accrualAnswer.Status {
+	case accrualclient.AccrualStatusProcessing:
+		if order.Data.Status != model.PurchaseOrderStatusProcessing {
+			order.Data.Status = model.PurchaseOrderStatusProcessing
+			err = service.orderPut(ctx, order)
+		}
+		return false, err
+	case accrualclient.AccrualStatusInvalid:
+		order.Data.Status = model.PurchaseOrderStatusInvalid
+		order.Data.Accrual = 0
+		_, err = service.orderComplete(ctx, order)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	case accrualclient.AccrualStatusProcessed:
+		// начисление с учетом множителя уровня пользователя
+		multiplier, err := service.tier.Multiplier(ctx, order.Data.Customer)
+		if err != nil {
+			return false, err
+		}
+		order.Data.Status = model.PurchaseOrderStatusProcessed
+		order.Data.BaseAccrual = accrualAnswer.Accrual
+		order.Data.Accrual = accrualAnswer.Accrual * multiplier / 100
+		// бонус за приглашение выплачивается в той же транзакции, если это первый обработанный заказ
+		_, err = service.orderComplete(ctx, order)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// PostAccrual принимает результат расчета, переданный системой начислений (push-режим)
+func (service *service) PostAccrual(ctx context.Context, accrualAnswer accrualclient.AccrualAnswer) error {
+	ctx, span := tracer.Start(ctx, "service.PostAccrual")
+	defer span.End()
+
+	if service.cfg.AccrualMode == config.AccrualModePoll {
+		return ErrNotFound
+	}
+	if accrualAnswer.Order == "" || accrualAnswer.Status == "" {
+		return ErrInsufficientData
+	}
+	switch accrualAnswer.Status {
+	case accrualclient.AccrualStatusRegistered,
+		accrualclient.AccrualStatusProcessing,
+		accrualclient.AccrualStatusInvalid,
+		accrualclient.AccrualStatusProcessed:
+	default:
+		return ErrUnprocessableEntity
+	}
+	if accrualAnswer.Accrual < 0 {
+		return ErrUnprocessableEntity
+	}
+
+	_, err := service.applyAccrual(ctx, accrualAnswer)
+	return err
+}
+
+// Минимальный возраст заказа для повторного опроса по умолчанию:
+// более свежие заказы, скорее всего, опрашиваются по расписанию без задержек
+const defaultRequeueAge = 10 * time.Minute
+
+// RequeueOrders назначает немедленный опрос необработанных заказов (NEW, PROCESSING),
+// загруженных раньше olderThan назад: отложенных с большой задержкой после ошибок
+// и прекращенных по истечении PollMaxAge. Счетчик попыток и срок опроса начинаются заново.
+// Возвращает заказы, поставленные в очередь
+func (service *service) RequeueOrders(ctx context.Context, olderThan time.Duration) ([]model.PurchaseOrder, error) {
+	ctx, span := tracer.Start(ctx, "service.RequeueOrders")
+	defer span.End()
+
+	if olderThan <= 0 {
+		olderThan = defaultRequeueAge
+	}
+	now := time.Now()
+	return service.store.PurchaseOrderRequeue(ctx, now.Add(-olderThan), now)
+}
+
+// ForceOrderStatus устанавливает статус необработанного заказа так, как если бы его
+// вернула система начислений: для PROCESSED начисляются баллы с учетом уровня пользователя.
+// Заказ в конечном статусе не меняется - для исправления баланса есть отмена списаний и сверка
+func (service *service) ForceOrderStatus(ctx context.Context, number string, status string, accrual int) (model.PurchaseOrder, error) {
+	ctx, span := tracer.Start(ctx, "service.ForceOrderStatus",
+		trace.WithAttributes(attribute.String("order.number", number)))
+	defer span.End()
+
+	if number == "" || status == "" {
+		return model.PurchaseOrder{}, ErrInsufficientData
+	}
+	switch status {
+	case model.PurchaseOrderStatusProcessing, model.PurchaseOrderStatusInvalid, model.PurchaseOrderStatusProcessed:
+	default:
+		return model.PurchaseOrder{}, ErrUnprocessableEntity
+	}
+	if accrual < 0 || (accrual > 0 && status != model.PurchaseOrderStatusProcessed) {
+		return model.PurchaseOrder{}, ErrUnprocessableEntity
+	}
+
+	order, err := service.store.PurchaseOrderGetByNumber(ctx, number)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return model.PurchaseOrder{}, ErrNotFound
+		}
+		return model.PurchaseOrder{}, err
+	}
+	switch order.Data.Status {
+	case model.PurchaseOrderStatusInvalid, model.PurchaseOrderStatusProcessed:
+		return model.PurchaseOrder{}, ErrConflict
+	}
+
+	// статусы заказа совпадают со статусами системы начислений
+	_, err = service.applyAccrual(ctx, accrualclient.AccrualAnswer{Order: number, Status: status, Accrual: accrual})
+	if err != nil {
+		return model.PurchaseOrder{}, err
+	}
+	return service.store.PurchaseOrderGetByNumber(ctx, number)
+}
 
+// orderComplete переводит заказ в конечный статус вместе с начислением и публикует изменение подписчикам.
+// Возвращает false, если заказ уже был в конечном статусе
+func (service *service) orderComplete(ctx context.Context, order model.PurchaseOrder) (bool, error) {
+	completed, err := service.store.PurchaseOrderComplete(ctx, order, service.referral.Policy())
+	if err != nil || !completed {
+		return false, err
+	}
+	service.broker.Publish(order)
+	return true, nil
+}
+
+// orderPut сохраняет заказ и публикует изменение подписчикам
+func (service *service) orderPut(ctx context.Context, order model.PurchaseOrder) error {
+	err := service.store.PurchaseOrderPut(ctx, order)
+	if err != nil {
+		return err
+	}
+	service.broker.Publish(order)
 	return nil
 }
 
-func (service *service) accrualProcessing(order model.PurchaseOrder) {
-	ctx := context.Background()
-
-	var accrualAnswer accrualclient.AccrualAnswer
-	var err error
-
-	ticker := time.NewTicker(5 * time.Second)
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			accrualAnswer, err = service.accrual.GetAccrual(order)
-			if err != nil {
-				// retry бы тут
-				return
-			}
-			switch accrualAnswer.Status {
-			case accrualclient.AccrualStatusProcessing:
-				if order.Data.Status != accrualclient.AccrualStatusProcessing {
-					order.Data.Status = model.PurchaseOrderStatusInvalid
-					service.store.PurchaseOrderPut(ctx, order)
-				}
-			case accrualclient.AccrualStatusInvalid:
-				order.Data.Status = model.PurchaseOrderStatusInvalid
-				service.store.PurchaseOrderPut(ctx, order)
-				return
-			case accrualclient.AccrualStatusProcessed:
-				order.Data.Status = model.PurchaseOrderStatusProcessed
-				order.Data.Accrual = accrualAnswer.Accrual
-				service.store.PurchaseOrderPut(ctx, order)
-				service.balance.Increase(order.Data.Customer, order.Number, accrualAnswer.Accrual)
-				return
-			default:
-			}
-		}
-	}
-}
-
-func (service *service) GetOrder(customer string) ([]model.PurchaseOrder, error) {
-	ctx := context.Background()
+func (service *service) GetOrder(ctx context.Context, customer string) ([]model.PurchaseOrder, error) {
+	ctx, span := tracer.Start(ctx, "service.GetOrder")
+	defer span.End()
 
 	if customer == "" {
 		return nil, ErrInsufficientData
@@ -132,15 +409,21 @@ func (service *service) GetOrder(customer string) ([]model.PurchaseOrder, error)
 	return service.store.PurchaseOrderGet(ctx, customer)
 }
 
-func (service *service) GetBalance(customer string) (model.Balance, error) {
+func (service *service) GetBalance(ctx context.Context, customer string) (model.Balance, error) {
+	ctx, span := tracer.Start(ctx, "service.GetBalance")
+	defer span.End()
+
 	if customer == "" {
 		return model.Balance{}, ErrInsufficientData
 	}
 
-	return service.balance.Get(customer)
+	return service.balance.Get(ctx, customer)
 }
 
-func (service *service) PostWithdraw(order model.PurchaseOrder, points int) error {
+func (service *service) PostWithdraw(ctx context.Context, order model.PurchaseOrder, points int) error {
+	ctx, span := tracer.Start(ctx, "service.PostWithdraw")
+	defer span.End()
+
 	if order.Number == "" {
 		return ErrInsufficientData
 	}
@@ -150,14 +433,18 @@ func (service *service) PostWithdraw(order model.PurchaseOrder, points int) erro
 	if points == 0 {
 		return ErrInsufficientData
 	}
-	// Проверка по алгоритму Луна
-	// ... ErrUnprocessableEntity
+	// Проверка длины и по алгоритму Луна
+	if !orderNumberValid(order.Number) {
+		return ErrUnprocessableEntity
+	}
 
-	err := service.balance.Decrease(order.Data.Customer, order.Number, points)
+	err := service.balance.Decrease(ctx, order.Data.Customer, order.Number, points)
 	if err != nil {
 		switch err {
 		case store.ErrInsufficientFunds:
 			return ErrInsufficientFunds
+		case store.ErrPointsIncorrect:
+			return ErrUnprocessableEntity
 		default:
 			return err
 		}
@@ -165,10 +452,259 @@ func (service *service) PostWithdraw(order model.PurchaseOrder, points int) erro
 	return nil
 }
 
-func (service *service) GetWithdrawals(customer string) ([]model.Balance, error) {
+func (service *service) GetWithdrawals(ctx context.Context, customer string) ([]model.Balance, error) {
+	ctx, span := tracer.Start(ctx, "service.GetWithdrawals")
+	defer span.End()
+
 	if customer == "" {
 		return nil, ErrInsufficientData
 	}
 
-	return service.balance.GetWithdrawals(customer)
+	return service.balance.GetWithdrawals(ctx, customer)
+}
+
+type Profile struct {
+	Customer string
+	Tier     tier.Status
+}
+
+func (service *service) GetProfile(ctx context.Context, customer string) (Profile, error) {
+	ctx, span := tracer.Start(ctx, "service.GetProfile")
+	defer span.End()
+
+	if customer == "" {
+		return Profile{}, ErrInsufficientData
+	}
+
+	status, err := service.tier.Get(ctx, customer)
+	if err != nil {
+		return Profile{}, err
+	}
+	return Profile{Customer: customer, Tier: status}, nil
+}
+
+func (service *service) GetReferrals(ctx context.Context, customer string) (referral.Summary, error) {
+	ctx, span := tracer.Start(ctx, "service.GetReferrals")
+	defer span.End()
+
+	if customer == "" {
+		return referral.Summary{}, ErrInsufficientData
+	}
+
+	summary, err := service.referral.Get(ctx, customer)
+	if err == store.ErrNotFound {
+		return referral.Summary{}, ErrNotFound
+	}
+	return summary, err
+}
+
+func (service *service) ReverseWithdrawal(ctx context.Context, customer string, operation string) (model.Balance, error) {
+	ctx, span := tracer.Start(ctx, "service.ReverseWithdrawal")
+	defer span.End()
+
+	if customer == "" || operation == "" {
+		return model.Balance{}, ErrInsufficientData
+	}
+
+	reversal, err := service.balance.Reverse(ctx, customer, operation)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return model.Balance{}, ErrNotFound
+		case store.ErrAlreadyReversed:
+			return model.Balance{}, ErrAlreadyExists
+		default:
+			return model.Balance{}, err
+		}
+	}
+	return reversal, nil
+}
+
+const (
+	defaultHoldTTL     = 15 * time.Minute
+	holdExpiryInterval = time.Minute
+)
+
+// AuthorizeWithdrawal резервирует баллы под заказ до подтверждения оплаты
+func (service *service) AuthorizeWithdrawal(ctx context.Context, order model.PurchaseOrder, points int, ttl time.Duration) (model.BalanceHold, error) {
+	ctx, span := tracer.Start(ctx, "service.AuthorizeWithdrawal")
+	defer span.End()
+
+	if order.Number == "" || order.Data.Customer == "" || points == 0 {
+		return model.BalanceHold{}, ErrInsufficientData
+	}
+	if points < 0 || ttl < 0 || len(order.Number) > maxOrderNumberLength {
+		return model.BalanceHold{}, ErrUnprocessableEntity
+	}
+	if ttl == 0 {
+		ttl = service.cfg.HoldTTL
+	}
+
+	hold, err := service.balance.Authorize(ctx, order.Data.Customer, order.Number, points, ttl)
+	if err != nil {
+		switch err {
+		case store.ErrInsufficientFunds:
+			return model.BalanceHold{}, ErrInsufficientFunds
+		case store.ErrPointsIncorrect:
+			return model.BalanceHold{}, ErrUnprocessableEntity
+		default:
+			return model.BalanceHold{}, err
+		}
+	}
+	return hold, nil
+}
+
+func (service *service) CaptureWithdrawal(ctx context.Context, hold string) (model.Balance, error) {
+	ctx, span := tracer.Start(ctx, "service.CaptureWithdrawal")
+	defer span.End()
+
+	if hold == "" {
+		return model.Balance{}, ErrInsufficientData
+	}
+
+	withdrawal, err := service.balance.Capture(ctx, hold)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return model.Balance{}, ErrNotFound
+		case store.ErrHoldNotActive:
+			return model.Balance{}, ErrConflict
+		case store.ErrInsufficientFunds:
+			return model.Balance{}, ErrInsufficientFunds
+		default:
+			return model.Balance{}, err
+		}
+	}
+	return withdrawal, nil
+}
+
+func (service *service) ReleaseWithdrawal(ctx context.Context, hold string) error {
+	ctx, span := tracer.Start(ctx, "service.ReleaseWithdrawal")
+	defer span.End()
+
+	if hold == "" {
+		return ErrInsufficientData
+	}
+
+	err := service.balance.Release(ctx, hold)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			return ErrNotFound
+		case store.ErrHoldNotActive:
+			return ErrConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// holdExpiry периодически помечает истекшие холды.
+// Истекшие холды не учитываются в доступном балансе и без этого, пометка нужна для истории.
+func (service *service) holdExpiry() {
+	ticker := time.NewTicker(holdExpiryInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		service.balance.ExpireHolds(context.Background())
+	}
+}
+
+func (service *service) PostWebhook(ctx context.Context, customer string, url string) (model.Webhook, error) {
+	ctx, span := tracer.Start(ctx, "service.PostWebhook")
+	defer span.End()
+
+	if customer == "" || url == "" {
+		return model.Webhook{}, ErrInsufficientData
+	}
+
+	newWebhook, err := service.webhook.Register(ctx, customer, url)
+	if err != nil {
+		switch err {
+		case webhook.ErrInvalidURL, webhook.ErrTooManyHooks:
+			return model.Webhook{}, ErrUnprocessableEntity
+		default:
+			return model.Webhook{}, err
+		}
+	}
+	return newWebhook, nil
+}
+
+func (service *service) GetWebhooks(ctx context.Context, customer string) ([]model.Webhook, error) {
+	ctx, span := tracer.Start(ctx, "service.GetWebhooks")
+	defer span.End()
+
+	if customer == "" {
+		return nil, ErrInsufficientData
+	}
+
+	return service.webhook.List(ctx, customer)
+}
+
+func (service *service) DeleteWebhook(ctx context.Context, customer string, id string) error {
+	ctx, span := tracer.Start(ctx, "service.DeleteWebhook")
+	defer span.End()
+
+	if customer == "" || id == "" {
+		return ErrInsufficientData
+	}
+
+	err := service.webhook.Delete(ctx, customer, id)
+	if err == webhook.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (service *service) GetWebhookDeliveries(ctx context.Context, customer string, id string) ([]model.WebhookDelivery, error) {
+	ctx, span := tracer.Start(ctx, "service.GetWebhookDeliveries")
+	defer span.End()
+
+	if customer == "" || id == "" {
+		return nil, ErrInsufficientData
+	}
+
+	deliveries, err := service.webhook.Deliveries(ctx, customer, id)
+	if err == webhook.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return deliveries, err
+}
+
+func (service *service) PingWebhook(ctx context.Context, customer string, id string) (model.WebhookDelivery, error) {
+	ctx, span := tracer.Start(ctx, "service.PingWebhook")
+	defer span.End()
+
+	if customer == "" || id == "" {
+		return model.WebhookDelivery{}, ErrInsufficientData
+	}
+
+	delivery, err := service.webhook.Ping(ctx, customer, id)
+	if err == webhook.ErrNotFound {
+		return model.WebhookDelivery{}, ErrNotFound
+	}
+	return delivery, err
+}
+
+func (service *service) SubscribeOrders(ctx context.Context, customer string, lastEventID uint64) ([]pubsub.OrderEvent, <-chan pubsub.OrderEvent, func(), error) {
+	ctx, span := tracer.Start(ctx, "service.SubscribeOrders")
+	defer span.End()
+
+	if customer == "" {
+		return nil, nil, nil, ErrInsufficientData
+	}
+
+	backlog, events, cancel, snapshotID := service.broker.Subscribe(customer, lastEventID)
+	if snapshotID != 0 {
+		// пропущенные события восстановить нельзя - вместо них передается текущее состояние заказов
+		orders, err := service.store.PurchaseOrderGet(ctx, customer)
+		if err != nil {
+			cancel()
+			return nil, nil, nil, err
+		}
+		for _, order := range orders {
+			backlog = append(backlog, pubsub.OrderEvent{ID: snapshotID, Order: order})
+		}
+	}
+	return backlog, events, cancel, nil
 }
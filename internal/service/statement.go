@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+)
+
+// StatementTotals - остаток на начало или конец периода выписки
+type StatementTotals struct {
+	Balance   int
+	Withdrawn int
+}
+
+// StatementWriter получает выписку по частям: входящий остаток, операции по порядку
+// и исходящий остаток. Так выписку за большой период можно отдавать клиенту потоком
+type StatementWriter interface {
+	Opening(totals StatementTotals) error
+	Entry(entry model.StatementEntry) error
+	Closing(totals StatementTotals) error
+}
+
+// GetStatement формирует выписку по счету пользователя за период [from, to).
+// Нулевой from - с первой операции
+func (service *service) GetStatement(ctx context.Context, customer string, from time.Time, to time.Time, w StatementWriter) error {
+	ctx, span := tracer.Start(ctx, "service.GetStatement")
+	defer span.End()
+
+	if customer == "" {
+		return ErrInsufficientData
+	}
+	if !from.Before(to) {
+		return ErrUnprocessableEntity
+	}
+
+	var totals StatementTotals
+	opened := false
+	err := service.store.BalanceGetStatement(ctx, customer, from, to, func(entry model.StatementEntry) error {
+		if entry.Opening {
+			totals = StatementTotals{Balance: entry.Entry.Data.Balance, Withdrawn: entry.Entry.Data.Withdrawn}
+			return nil
+		}
+		if !opened {
+			opened = true
+			if err := w.Opening(totals); err != nil {
+				return err
+			}
+		}
+		totals = StatementTotals{Balance: entry.Entry.Data.Balance, Withdrawn: entry.Entry.Data.Withdrawn}
+		return w.Entry(entry)
+	})
+	if err != nil {
+		return err
+	}
+	if !opened {
+		// операций за период нет
+		if err := w.Opening(totals); err != nil {
+			return err
+		}
+	}
+	return w.Closing(totals)
+}
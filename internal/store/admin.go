@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/iurnickita/gophermart/internal/model"
+)
+
+func (store *store) AdminUserPost(ctx context.Context, admin model.AdminUser) error {
+	//Создание администратора
+	result, err := store.database.ExecContext(ctx,
+		"INSERT INTO admin_user (login, token_hash, created_at)"+
+			" VALUES ($1, $2, $3)"+
+			" ON CONFLICT DO NOTHING",
+		admin.Login,
+		admin.Data.TokenHash,
+		admin.Data.CreatedAt)
+	if err != nil {
+		return err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+func (store *store) AdminUserGet(ctx context.Context) ([]model.AdminUser, error) {
+	//Получение всех администраторов
+	rows, err := store.database.QueryContext(ctx,
+		"SELECT login, token_hash, created_at"+
+			" FROM admin_user"+
+			" ORDER BY login")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var admins []model.AdminUser
+	for rows.Next() {
+		var admin model.AdminUser
+		err := rows.Scan(&admin.Login, &admin.Data.TokenHash, &admin.Data.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		admins = append(admins, admin)
+	}
+	return admins, rows.Err()
+}
+
+func (store *store) AdminUserGetByTokenHash(ctx context.Context, tokenHash string) (model.AdminUser, error) {
+	//Получение администратора по хешу токена
+	var admin model.AdminUser
+	err := store.database.QueryRowContext(ctx,
+		"SELECT login, token_hash, created_at"+
+			" FROM admin_user"+
+			" WHERE token_hash = $1",
+		tokenHash).Scan(&admin.Login, &admin.Data.TokenHash, &admin.Data.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return model.AdminUser{}, ErrNotFound
+		}
+		return model.AdminUser{}, err
+	}
+	return admin, nil
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/iurnickita/gophermart/internal/model"
+)
+
+const auditColumns = "id, timestamp, action, actor, ip, target, outcome, detail, request_id"
+
+// Максимальное количество записей аудита в одной выборке
+const auditMaxLimit = 1000
+
+func (store *store) AuditPost(ctx context.Context, event model.AuditEvent) error {
+	//Добавление записи аудита
+	_, err := store.database.ExecContext(ctx,
+		"INSERT INTO audit_log (timestamp, action, actor, ip, target, outcome, detail, request_id)"+
+			" VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		event.Data.Timestamp,
+		event.Data.Action,
+		event.Data.Actor,
+		event.Data.IP,
+		event.Data.Target,
+		event.Data.Outcome,
+		event.Data.Detail,
+		event.Data.RequestID)
+	return err
+}
+
+func (store *store) AuditGet(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error) {
+	//Получение записей аудита от новых к старым
+	var conditions []string
+	var args []any
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, condition+" $"+strconv.Itoa(len(args)))
+	}
+	if filter.Actor != "" {
+		addCondition("actor =", filter.Actor)
+	}
+	if filter.Action != "" {
+		addCondition("action =", filter.Action)
+	}
+	if filter.Target != "" {
+		addCondition("target =", filter.Target)
+	}
+	if filter.Outcome != "" {
+		addCondition("outcome =", filter.Outcome)
+	}
+	if !filter.From.IsZero() {
+		addCondition("timestamp >=", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("timestamp <", filter.To)
+	}
+	if filter.BeforeID != "" {
+		addCondition("id <", filter.BeforeID)
+	}
+	if filter.Limit <= 0 || filter.Limit > auditMaxLimit {
+		filter.Limit = auditMaxLimit
+	}
+
+	query := "SELECT " + auditColumns + " FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := store.database.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []model.AuditEvent
+	for rows.Next() {
+		var event model.AuditEvent
+		err = rows.Scan(&event.ID,
+			&event.Data.Timestamp,
+			&event.Data.Action,
+			&event.Data.Actor,
+			&event.Data.IP,
+			&event.Data.Target,
+			&event.Data.Outcome,
+			&event.Data.Detail,
+			&event.Data.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,277 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const customerColumns = "code, login, password_hash, referral_code, created_at"
+
+func scanCustomer(row rowScanner, customer *model.Customer) error {
+	return row.Scan(&customer.Code,
+		&customer.Data.Login,
+		&customer.Data.PasswordHash,
+		&customer.Data.ReferralCode,
+		&customer.Data.CreatedAt)
+}
+
+// CustomerPost регистрирует пользователя и, если указан пригласивший, записывает приглашение.
+// Занятый логин - ErrAlreadyExists, совпадение сгенерированного кода с существующим - ErrCodeCollision
+func (store *store) CustomerPost(ctx context.Context, customer model.Customer, referrer string) error {
+	err := store.inTx(ctx, func(tx *tracedTx) error {
+		//Регистрация пользователя
+		result, err := tx.ExecContext(ctx,
+			"INSERT INTO customer ("+customerColumns+")"+
+				" VALUES ($1, $2, $3, $4, $5)"+
+				" ON CONFLICT (login) DO NOTHING",
+			customer.Code,
+			customer.Data.Login,
+			customer.Data.PasswordHash,
+			customer.Data.ReferralCode,
+			customer.Data.CreatedAt)
+		if err != nil {
+			return err
+		}
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if inserted == 0 {
+			return ErrAlreadyExists
+		}
+		if referrer == "" {
+			return nil
+		}
+
+		//Запись приглашения
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO referral (referee, referrer, created_at)"+
+				" VALUES ($1, $2, $3)",
+			customer.Code,
+			referrer,
+			customer.Data.CreatedAt)
+		return err
+	})
+	// Конфликт по логину обработан выше, остальные уникальные поля - сгенерированные коды
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrCodeCollision
+	}
+	return err
+}
+
+func (store *store) CustomerGet(ctx context.Context, code string) (model.Customer, error) {
+	return store.customerGetBy(ctx, "code", code)
+}
+
+func (store *store) CustomerGetByLogin(ctx context.Context, login string) (model.Customer, error) {
+	return store.customerGetBy(ctx, "login", login)
+}
+
+func (store *store) CustomerGetByReferralCode(ctx context.Context, referralCode string) (model.Customer, error) {
+	return store.customerGetBy(ctx, "referral_code", referralCode)
+}
+
+// Максимальное количество пользователей в выборке CustomerFind
+const maxCustomerFind = 1000
+
+func (store *store) CustomerFind(ctx context.Context, filter model.CustomerFilter) ([]model.Customer, error) {
+	//Поиск пользователей по части логина или коду
+	if filter.Limit <= 0 || filter.Limit > maxCustomerFind {
+		filter.Limit = maxCustomerFind
+	}
+	rows, err := store.database.QueryContext(ctx,
+		"SELECT "+customerColumns+
+			" FROM customer"+
+			" WHERE $1 = '' OR code = $1 OR strpos(lower(login), lower($1)) > 0"+
+			" ORDER BY login"+
+			" LIMIT $2",
+		filter.Query,
+		filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var customers []model.Customer
+	for rows.Next() {
+		var customer model.Customer
+		err := scanCustomer(rows, &customer)
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, customer)
+	}
+	return customers, rows.Err()
+}
+
+// customerGetBy - выборка пользователя по уникальной колонке.
+// column подставляется в запрос, поэтому передается только константой
+func (store *store) customerGetBy(ctx context.Context, column string, value string) (model.Customer, error) {
+	var customer model.Customer
+	row := store.database.QueryRowContext(ctx,
+		"SELECT "+customerColumns+
+			" FROM customer"+
+			" WHERE "+column+" = $1",
+		value)
+	err := scanCustomer(row, &customer)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return model.Customer{}, ErrNotFound
+		}
+		return model.Customer{}, err
+	}
+	return customer, nil
+}
+
+const referralColumns = "referee, referrer, created_at, rewarded_at, referrer_bonus, referee_bonus"
+
+func scanReferral(row rowScanner, referral *model.Referral) error {
+	var rewardedAt sql.NullTime
+	err := row.Scan(&referral.Referee,
+		&referral.Data.Referrer,
+		&referral.Data.CreatedAt,
+		&rewardedAt,
+		&referral.Data.ReferrerBonus,
+		&referral.Data.RefereeBonus)
+	if err != nil {
+		return err
+	}
+	referral.Data.RewardedAt = rewardedAt.Time
+	return nil
+}
+
+func (store *store) ReferralGet(ctx context.Context, referrer string) ([]model.Referral, error) {
+	//Получение приглашенных пользователем
+	rows, err := store.database.QueryContext(ctx,
+		"SELECT "+referralColumns+
+			" FROM referral"+
+			" WHERE referrer = $1"+
+			" ORDER BY created_at",
+		referrer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var referrals []model.Referral
+	for rows.Next() {
+		var referral model.Referral
+		err := scanReferral(rows, &referral)
+		if err != nil {
+			return nil, err
+		}
+		referrals = append(referrals, referral)
+	}
+
+	return referrals, rows.Err()
+}
+
+func (store *store) ReferralGetByReferee(ctx context.Context, referee string) (model.Referral, error) {
+	var referral model.Referral
+	row := store.database.QueryRowContext(ctx,
+		"SELECT "+referralColumns+
+			" FROM referral"+
+			" WHERE referee = $1",
+		referee)
+	err := scanReferral(row, &referral)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return model.Referral{}, ErrNotFound
+		}
+		return model.Referral{}, err
+	}
+	return referral, nil
+}
+
+// referralReward выплачивает бонусы по приглашению за обработанный заказ приглашенного
+// и отмечает приглашение вознагражденным. Вызывается в транзакции PurchaseOrderComplete,
+// балансы обоих пользователей уже заблокированы. Бонус выплачивается один раз и только
+// за первый обработанный заказ: если он не подходит по условиям, бонуса не будет
+func (store *store) referralReward(ctx context.Context, tx *tracedTx, referral model.Referral, order model.PurchaseOrder, policy model.ReferralPolicy) error {
+	//Обработанные ранее заказы приглашенного
+	var processedBefore bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1"+
+			" FROM purchase_order"+
+			" WHERE customer = $1"+
+			"   AND status = $2"+
+			"   AND number <> $3)",
+		order.Data.Customer,
+		model.PurchaseOrderStatusProcessed,
+		order.Number).Scan(&processedBefore)
+	if err != nil {
+		return err
+	}
+	if processedBefore || !referral.Data.RewardedAt.IsZero() {
+		return nil
+	}
+	if order.Data.Accrual <= 0 || order.Data.Accrual < policy.MinOrderAccrual {
+		return nil
+	}
+
+	//Количество вознагражденных приглашений пригласившего
+	var rewarded int
+	err = tx.QueryRowContext(ctx,
+		"SELECT COUNT(*)"+
+			" FROM referral"+
+			" WHERE referrer = $1"+
+			"   AND rewarded_at IS NOT NULL",
+		referral.Data.Referrer).Scan(&rewarded)
+	if err != nil {
+		return err
+	}
+	if rewarded >= policy.MaxRewardsPerReferrer {
+		return nil
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE referral"+
+			" SET rewarded_at = $1,"+
+			"     referrer_bonus = $2,"+
+			"     referee_bonus = $3"+
+			" WHERE referee = $4"+
+			"   AND rewarded_at IS NULL",
+		time.Now(),
+		policy.ReferrerBonus,
+		policy.RefereeBonus,
+		referral.Referee)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return nil
+	}
+
+	bonuses := []struct {
+		customer string
+		points   int
+	}{
+		{referral.Data.Referrer, policy.ReferrerBonus},
+		{referral.Referee, policy.RefereeBonus},
+	}
+	for _, bonus := range bonuses {
+		if bonus.points <= 0 {
+			continue
+		}
+		_, err = store.balanceCredit(ctx, tx, bonus.customer, "", model.BalanceKindBonus, bonus.points, referral.Referee)
+		if err != nil {
+			return err
+		}
+		err = store.outboxInsert(ctx, tx, model.EventData{
+			Type:     model.EventBonusAccrued,
+			Customer: bonus.customer,
+			Points:   bonus.points})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+)
+
+const holdColumns = "id, customer, \"order\", points, status, created_at, expires_at, operation"
+
+func scanHold(row rowScanner, hold *model.BalanceHold) error {
+	return row.Scan(&hold.ID,
+		&hold.Data.Customer,
+		&hold.Data.Order,
+		&hold.Data.Points,
+		&hold.Data.Status,
+		&hold.Data.CreatedAt,
+		&hold.Data.ExpiresAt,
+		&hold.Data.Operation)
+}
+
+func (store *store) BalanceGetOnHold(ctx context.Context, customer string) (int, error) {
+	return store.balanceGetOnHold(ctx, store.database, customer)
+}
+
+func (store *store) balanceGetOnHold(ctx context.Context, q querier, customer string) (int, error) {
+	//Сумма активных холдов. Истекшие холды не учитываются, даже если еще не помечены
+	var onHold int
+	row := q.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(points), 0)"+
+			" FROM balance_hold"+
+			" WHERE customer = $1"+
+			"   AND status = $2"+
+			"   AND expires_at > $3",
+		customer, model.BalanceHoldStatusActive, time.Now())
+	err := row.Scan(&onHold)
+	if err != nil {
+		return 0, err
+	}
+	return onHold, nil
+}
+
+func (store *store) BalanceHoldPost(ctx context.Context, hold model.BalanceHold) (model.BalanceHold, error) {
+	//Блокировка баланса пользователя
+	mutex := store.customerMutex(hold.Data.Customer)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if hold.Data.Points <= 0 {
+		return model.BalanceHold{}, ErrPointsIncorrect
+	}
+
+	err := store.inTx(ctx, func(tx *tracedTx) error {
+		err := store.lockBalance(ctx, tx, hold.Data.Customer)
+		if err != nil {
+			return err
+		}
+		//Проверка доступных средств: баланс за вычетом активных холдов
+		balanceRow, err := store.balanceGetActual(ctx, tx, hold.Data.Customer)
+		if err != nil {
+			return err
+		}
+		onHold, err := store.balanceGetOnHold(ctx, tx, hold.Data.Customer)
+		if err != nil {
+			return err
+		}
+		if balanceRow.Data.Balance-onHold < hold.Data.Points {
+			return ErrInsufficientFunds
+		}
+
+		//Запись холда
+		hold.Data.Status = model.BalanceHoldStatusActive
+		row := tx.QueryRowContext(ctx,
+			"INSERT INTO balance_hold (customer, \"order\", points, status, created_at, expires_at)"+
+				" VALUES ($1, $2, $3, $4, $5, $6)"+
+				" RETURNING id",
+			hold.Data.Customer,
+			hold.Data.Order,
+			hold.Data.Points,
+			hold.Data.Status,
+			hold.Data.CreatedAt,
+			hold.Data.ExpiresAt)
+		return row.Scan(&hold.ID)
+	})
+	if err != nil {
+		return model.BalanceHold{}, err
+	}
+	return hold, nil
+}
+
+// BalanceHoldCapture превращает активный холд в списание
+func (store *store) BalanceHoldCapture(ctx context.Context, id string) (model.Balance, error) {
+	hold, err := store.balanceHoldGet(ctx, store.database, id)
+	if err != nil {
+		return model.Balance{}, err
+	}
+
+	//Блокировка баланса пользователя
+	mutex := store.customerMutex(hold.Data.Customer)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var withdrawal model.Balance
+	err = store.inTx(ctx, func(tx *tracedTx) error {
+		err := store.lockBalance(ctx, tx, hold.Data.Customer)
+		if err != nil {
+			return err
+		}
+		//Повторное чтение под блокировкой
+		hold, err := store.balanceHoldGet(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if hold.Data.Status != model.BalanceHoldStatusActive || !hold.Data.ExpiresAt.After(time.Now()) {
+			return ErrHoldNotActive
+		}
+
+		//Баллы этого холда уже зарезервированы, остальные холды трогать нельзя
+		onHold, err := store.balanceGetOnHold(ctx, tx, hold.Data.Customer)
+		if err != nil {
+			return err
+		}
+		withdrawal, err = store.balanceWithdraw(ctx, tx, hold.Data.Customer, hold.Data.Order,
+			hold.Data.Points, onHold-hold.Data.Points)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"UPDATE balance_hold"+
+				" SET status = $1,"+
+				"     operation = $2"+
+				" WHERE id = $3",
+			model.BalanceHoldStatusCaptured, withdrawal.Key.Operation, id)
+		return err
+	})
+	if err != nil {
+		return model.Balance{}, err
+	}
+	return withdrawal, nil
+}
+
+func (store *store) BalanceHoldRelease(ctx context.Context, id string) error {
+	//Освобождение активного холда
+	result, err := store.database.ExecContext(ctx,
+		"UPDATE balance_hold"+
+			" SET status = $1"+
+			" WHERE id = $2"+
+			"   AND status = $3",
+		model.BalanceHoldStatusReleased, id, model.BalanceHoldStatusActive)
+	if err != nil {
+		return err
+	}
+	released, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if released == 0 {
+		_, err = store.balanceHoldGet(ctx, store.database, id)
+		if err != nil {
+			return err
+		}
+		return ErrHoldNotActive
+	}
+	return nil
+}
+
+func (store *store) BalanceHoldExpire(ctx context.Context) (int, error) {
+	//Пометка истекших холдов
+	result, err := store.database.ExecContext(ctx,
+		"UPDATE balance_hold"+
+			" SET status = $1"+
+			" WHERE status = $2"+
+			"   AND expires_at <= $3",
+		model.BalanceHoldStatusExpired, model.BalanceHoldStatusActive, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	expired, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(expired), nil
+}
+
+func (store *store) balanceHoldGet(ctx context.Context, q querier, id string) (model.BalanceHold, error) {
+	var hold model.BalanceHold
+	row := q.QueryRowContext(ctx,
+		"SELECT "+holdColumns+
+			" FROM balance_hold"+
+			" WHERE id = $1",
+		id)
+	err := scanHold(row, &hold)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return model.BalanceHold{}, ErrNotFound
+		}
+		return model.BalanceHold{}, err
+	}
+	return hold, nil
+}
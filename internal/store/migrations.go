@@ -0,0 +1,291 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migration - версия схемы БД. Миграции применяются по порядку, каждая в своей транзакции,
+// номер примененной версии записывается в schema_migrations.
+// Новые изменения схемы добавляются только новой миграцией в конец списка
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+var migrations = []migration{
+	{version: 1, name: "initial schema", statements: []string{
+		// Таблица баланса пользователя
+		// Представлят собой журнал. Для каждой новой операции пользователя создается новая запись,
+		// так легче отслеживать историю и выявлять ошибки при операциях с балансом
+		// [не реализовано] Блокировка на уровне пользователя *костыль: store.balanceMutex[customer]mutex
+		// [не реализовано] Записи нельзя редактировать/удалять
+		// Вид операции (kind): начисление, списание, корректировка по итогам сверки, отмена списания.
+		// reference - операция, на которую ссылается запись; списание можно отменить только один раз
+		"CREATE TABLE IF NOT EXISTS balance (" +
+			" operation SERIAL PRIMARY KEY," +
+			" customer VARCHAR (10) NOT NULL," +
+			" timestamp TIMESTAMP NOT NULL," +
+			" kind VARCHAR (16) NOT NULL DEFAULT ''," +
+			" difference INTEGER NOT NULL," +
+			" balance INTEGER," +
+			" withdrawn INTEGER," +
+			" \"order\" VARCHAR (10) NOT NULL," +
+			" reference VARCHAR (20) NOT NULL DEFAULT ''" +
+			" );",
+		"CREATE UNIQUE INDEX IF NOT EXISTS balance_reversal" +
+			" ON balance (reference)" +
+			" WHERE kind = 'REVERSAL';",
+
+		// Таблица холдов (двухфазных списаний).
+		// Активный холд резервирует баллы до подтверждения (capture), освобождения (release)
+		// или истечения срока expires_at
+		"CREATE TABLE IF NOT EXISTS balance_hold (" +
+			" id SERIAL PRIMARY KEY," +
+			" customer VARCHAR (10) NOT NULL," +
+			" \"order\" VARCHAR (10) NOT NULL," +
+			" points INTEGER NOT NULL," +
+			" status VARCHAR (10) NOT NULL," +
+			" created_at TIMESTAMP NOT NULL," +
+			" expires_at TIMESTAMP NOT NULL," +
+			" operation VARCHAR (20) NOT NULL DEFAULT ''" +
+			" );",
+
+		// Таблица исходящих событий (transactional outbox).
+		// Событие записывается в той же транзакции, что и изменение заказа или баланса,
+		// и затем доставляется диспетчером outbox
+		"CREATE TABLE IF NOT EXISTS outbox (" +
+			" id SERIAL PRIMARY KEY," +
+			" type VARCHAR (32) NOT NULL," +
+			" customer VARCHAR (10) NOT NULL," +
+			" \"order\" VARCHAR (10) NOT NULL," +
+			" status VARCHAR (10) NOT NULL," +
+			" points INTEGER NOT NULL," +
+			" created_at TIMESTAMP NOT NULL," +
+			" attempts INTEGER NOT NULL DEFAULT 0," +
+			" next_attempt_at TIMESTAMP NOT NULL," +
+			" delivered_at TIMESTAMP," +
+			" last_error TEXT NOT NULL DEFAULT ''" +
+			" );",
+
+		// Пользователи
+		"CREATE TABLE IF NOT EXISTS customer (" +
+			" code VARCHAR (10) PRIMARY KEY," +
+			" login VARCHAR (64) NOT NULL UNIQUE," +
+			" password_hash VARCHAR (72) NOT NULL," +
+			" referral_code VARCHAR (16) NOT NULL UNIQUE," +
+			" created_at TIMESTAMP NOT NULL" +
+			" );",
+
+		// Реферальная программа.
+		// Пользователь может быть приглашен только один раз, бонус начисляется один раз (rewarded_at)
+		"CREATE TABLE IF NOT EXISTS referral (" +
+			" referee VARCHAR (10) PRIMARY KEY," +
+			" referrer VARCHAR (10) NOT NULL," +
+			" created_at TIMESTAMP NOT NULL," +
+			" rewarded_at TIMESTAMP," +
+			" referrer_bonus INTEGER NOT NULL DEFAULT 0," +
+			" referee_bonus INTEGER NOT NULL DEFAULT 0" +
+			" );",
+
+		// Уровни программы лояльности, пересчитываются по расписанию
+		"CREATE TABLE IF NOT EXISTS customer_tier (" +
+			" customer VARCHAR (10) PRIMARY KEY," +
+			" tier VARCHAR (32) NOT NULL," +
+			" lifetime_accrued INTEGER NOT NULL," +
+			" updated_at TIMESTAMP NOT NULL" +
+			" );",
+
+		// Вебхуки пользователей и журнал попыток их доставки
+		"CREATE TABLE IF NOT EXISTS webhook (" +
+			" id SERIAL PRIMARY KEY," +
+			" customer VARCHAR (10) NOT NULL," +
+			" url TEXT NOT NULL," +
+			" secret VARCHAR (64) NOT NULL," +
+			" created_at TIMESTAMP NOT NULL" +
+			" );",
+		"CREATE TABLE IF NOT EXISTS webhook_delivery (" +
+			" id SERIAL PRIMARY KEY," +
+			" webhook INTEGER NOT NULL," +
+			" event VARCHAR (32) NOT NULL," +
+			" \"order\" VARCHAR (10) NOT NULL," +
+			" attempt INTEGER NOT NULL," +
+			" status_code INTEGER NOT NULL," +
+			" error TEXT NOT NULL," +
+			" success BOOLEAN NOT NULL," +
+			" timestamp TIMESTAMP NOT NULL" +
+			" );",
+
+		// Таблица заказов.
+		// Создается одна строка на заказ, после чего меняется ее статус
+		"CREATE TABLE IF NOT EXISTS purchase_order (" +
+			" number VARCHAR (10) PRIMARY KEY," +
+			" customer VARCHAR (10) NOT NULL," +
+			" status VARCHAR (10) NOT NULL," +
+			" accrual INTEGER NOT NULL," +
+			" uploaded_at TIMESTAMP NOT NULL" +
+			" );",
+	}},
+	{version: 2, name: "audit log", statements: []string{
+		// Журнал аудита безопасности.
+		// Записи только добавляются: изменение и удаление запрещены триггером
+		"CREATE TABLE IF NOT EXISTS audit_log (" +
+			" id BIGSERIAL PRIMARY KEY," +
+			" timestamp TIMESTAMP NOT NULL," +
+			" action VARCHAR (32) NOT NULL," +
+			" actor VARCHAR (64) NOT NULL," +
+			" ip VARCHAR (45) NOT NULL," +
+			" target VARCHAR (64) NOT NULL," +
+			" outcome VARCHAR (10) NOT NULL," +
+			" detail TEXT NOT NULL," +
+			" request_id VARCHAR (128) NOT NULL" +
+			" )",
+		"CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log (actor, id)",
+		"CREATE INDEX IF NOT EXISTS audit_log_action ON audit_log (action, id)",
+		"CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$" +
+			" BEGIN" +
+			"   RAISE EXCEPTION 'audit_log is append-only';" +
+			" END;" +
+			" $$ LANGUAGE plpgsql",
+		"CREATE TRIGGER audit_log_append_only" +
+			" BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log" +
+			" FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only()",
+	}},
+	{version: 3, name: "admin users", statements: []string{
+		// Администраторы с персональными токенами (создаются через gophermartctl)
+		"CREATE TABLE IF NOT EXISTS admin_user (" +
+			" login VARCHAR (64) PRIMARY KEY," +
+			" token_hash CHAR (64) NOT NULL UNIQUE," +
+			" created_at TIMESTAMP NOT NULL" +
+			" )",
+	}},
+	{version: 4, name: "order poll schedule", statements: []string{
+		// Расписание опроса системы начислений по заказу.
+		// next_poll_at пусто - опрос не нужен (конечный статус или dead letter),
+		// dead_lettered_at - опрос прекращен по истечении максимального срока
+		"ALTER TABLE purchase_order" +
+			" ADD COLUMN IF NOT EXISTS next_poll_at TIMESTAMP," +
+			" ADD COLUMN IF NOT EXISTS poll_attempts INTEGER NOT NULL DEFAULT 0," +
+			" ADD COLUMN IF NOT EXISTS dead_lettered_at TIMESTAMP",
+		// необработанные заказы, загруженные до миграции, опрашиваются заново
+		"UPDATE purchase_order SET next_poll_at = uploaded_at" +
+			" WHERE status IN ('NEW', 'PROCESSING')",
+		"CREATE INDEX IF NOT EXISTS purchase_order_next_poll" +
+			" ON purchase_order (next_poll_at)" +
+			" WHERE next_poll_at IS NOT NULL",
+	}},
+	{version: 5, name: "outbox dead letters", statements: []string{
+		// dead_at - доставка события прекращена после исчерпания попыток
+		"ALTER TABLE outbox ADD COLUMN IF NOT EXISTS dead_at TIMESTAMP",
+		"CREATE INDEX IF NOT EXISTS outbox_pending" +
+			" ON outbox (next_attempt_at)" +
+			" WHERE delivered_at IS NULL AND dead_at IS NULL",
+	}},
+	{version: 6, name: "long order numbers", statements: []string{
+		// Номер заказа проверяется только алгоритмом Луна и может быть длиннее 10 цифр
+		"ALTER TABLE purchase_order ALTER COLUMN number TYPE VARCHAR (32)",
+		"ALTER TABLE balance ALTER COLUMN \"order\" TYPE VARCHAR (32)",
+		"ALTER TABLE balance_hold ALTER COLUMN \"order\" TYPE VARCHAR (32)",
+		"ALTER TABLE outbox ALTER COLUMN \"order\" TYPE VARCHAR (32)",
+		"ALTER TABLE webhook_delivery ALTER COLUMN \"order\" TYPE VARCHAR (32)",
+	}},
+	{version: 7, name: "order base accrual", statements: []string{
+		// Начисление системы начислений без множителя уровня, по нему считается уровень.
+		// Для уже обработанных заказов исходная сумма неизвестна, берется начисленная
+		"ALTER TABLE purchase_order ADD COLUMN base_accrual INTEGER NOT NULL DEFAULT 0",
+		"UPDATE purchase_order SET base_accrual = accrual",
+	}},
+	{version: 8, name: "order poll start", statements: []string{
+		// Начало текущего цикла опроса: время загрузки заказа или последнего возобновления опроса.
+		// По нему, а не по времени загрузки, опрос прекращается через PollMaxAge
+		"ALTER TABLE purchase_order ADD COLUMN poll_started_at TIMESTAMP",
+		"UPDATE purchase_order SET poll_started_at = uploaded_at",
+		"ALTER TABLE purchase_order ALTER COLUMN poll_started_at SET NOT NULL",
+	}},
+	{version: 9, name: "outbox deliveries per sink", statements: []string{
+		// Доставка события каждому приемнику отслеживается отдельно: отказ одного приемника
+		// не приводит к повторной отправке в остальные и не расходует их попытки
+		"CREATE TABLE IF NOT EXISTS outbox_delivery (" +
+			" event INTEGER NOT NULL," +
+			" sink VARCHAR (32) NOT NULL," +
+			" attempts INTEGER NOT NULL DEFAULT 0," +
+			" next_attempt_at TIMESTAMP NOT NULL," +
+			" delivered_at TIMESTAMP," +
+			" dead_at TIMESTAMP," +
+			" last_error TEXT NOT NULL DEFAULT ''," +
+			" PRIMARY KEY (event, sink)" +
+			" )",
+	}},
+}
+
+// ExpectedSchemaVersion возвращает версию схемы, с которой работает текущая сборка
+func ExpectedSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion возвращает последнюю примененную версию схемы
+func (store *store) SchemaVersion(ctx context.Context) (int, error) {
+	//Получение версии
+	var version int
+	err := store.database.QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// migrate применяет недостающие миграции.
+// Advisory-блокировка не дает нескольким экземплярам применять одну миграцию одновременно
+func migrate(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS schema_migrations ("+
+			" version INTEGER PRIMARY KEY,"+
+			" name VARCHAR (64) NOT NULL,"+
+			" applied_at TIMESTAMP NOT NULL"+
+			" )")
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		err = applyMigration(ctx, db, m)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('schema_migrations'))")
+	if err != nil {
+		return err
+	}
+	var applied bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)",
+		m.version).Scan(&applied)
+	if err != nil || applied {
+		return err
+	}
+
+	for _, statement := range m.statements {
+		_, err = tx.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, NOW())",
+		m.version, m.name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
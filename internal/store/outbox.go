@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+)
+
+func (store *store) outboxInsert(ctx context.Context, q querier, event model.EventData) error {
+	//Запись события в outbox
+	now := time.Now()
+	_, err := q.ExecContext(ctx,
+		"INSERT INTO outbox (type, customer, \"order\", status, points, created_at, next_attempt_at)"+
+			" VALUES ($1, $2, $3, $4, $5, $6, $6)",
+		event.Type,
+		event.Customer,
+		event.Order,
+		event.Status,
+		event.Points,
+		now)
+	return err
+}
+
+func (store *store) OutboxClaimPending(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]model.Event, error) {
+	//Захват недоставленных событий, для которых подошло время попытки.
+	//next_attempt_at сдвигается на время аренды: другие экземпляры не отправят событие повторно,
+	//а если экземпляр упадет, событие снова станет доступно после окончания аренды
+	rows, err := store.database.QueryContext(ctx,
+		"UPDATE outbox SET next_attempt_at = $2"+
+			" WHERE id IN ("+
+			"  SELECT id FROM outbox"+
+			"  WHERE delivered_at IS NULL"+
+			"    AND dead_at IS NULL"+
+			"    AND next_attempt_at <= $1"+
+			"  ORDER BY id"+
+			"  LIMIT $3"+
+			"  FOR UPDATE SKIP LOCKED)"+
+			" RETURNING id, type, customer, \"order\", status, points, created_at, attempts",
+		now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []model.Event
+	for rows.Next() {
+		var event model.Event
+		err := rows.Scan(&event.ID,
+			&event.Data.Type,
+			&event.Data.Customer,
+			&event.Data.Order,
+			&event.Data.Status,
+			&event.Data.Points,
+			&event.Data.CreatedAt,
+			&event.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (store *store) OutboxMarkDelivered(ctx context.Context, id string) error {
+	//Отметка о доставке события
+	_, err := store.database.ExecContext(ctx,
+		"UPDATE outbox"+
+			" SET delivered_at = $1,"+
+			"     attempts = attempts + 1"+
+			" WHERE id = $2",
+		time.Now(), id)
+	return err
+}
+
+func (store *store) OutboxMarkFailed(ctx context.Context, id string, nextAttemptAt time.Time, lastError string) error {
+	//Неудачная попытка доставки: событие будет повторено после nextAttemptAt
+	_, err := store.database.ExecContext(ctx,
+		"UPDATE outbox"+
+			" SET attempts = attempts + 1,"+
+			"     next_attempt_at = $1,"+
+			"     last_error = $2"+
+			" WHERE id = $3",
+		nextAttemptAt, lastError, id)
+	return err
+}
+
+func (store *store) OutboxMarkDead(ctx context.Context, id string, attempts int, lastError string) error {
+	//Доставка прекращена: попытки исчерпаны
+	_, err := store.database.ExecContext(ctx,
+		"UPDATE outbox"+
+			" SET dead_at = $1,"+
+			"     attempts = $2,"+
+			"     last_error = $3"+
+			" WHERE id = $4",
+		time.Now(), attempts, lastError, id)
+	return err
+}
+
+func (store *store) OutboxRelease(ctx context.Context, id string, now time.Time) error {
+	//Снятие аренды с события, до которого не дошла очередь
+	_, err := store.database.ExecContext(ctx,
+		"UPDATE outbox SET next_attempt_at = $1"+
+			" WHERE id = $2",
+		now, id)
+	return err
+}
+
+func (store *store) OutboxDeliveryGet(ctx context.Context, event string) ([]model.EventDelivery, error) {
+	//Получение состояния доставки события по приемникам
+	rows, err := store.database.QueryContext(ctx,
+		"SELECT event, sink, attempts, next_attempt_at, delivered_at, dead_at, last_error"+
+			" FROM outbox_delivery"+
+			" WHERE event = $1",
+		event)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []model.EventDelivery
+	for rows.Next() {
+		var delivery model.EventDelivery
+		var deliveredAt, deadAt sql.NullTime
+		err := rows.Scan(&delivery.Event,
+			&delivery.Sink,
+			&delivery.Data.Attempts,
+			&delivery.Data.NextAttemptAt,
+			&deliveredAt,
+			&deadAt,
+			&delivery.Data.LastError)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Data.DeliveredAt = deliveredAt.Time
+		delivery.Data.DeadAt = deadAt.Time
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (store *store) OutboxDeliveryPut(ctx context.Context, delivery model.EventDelivery) error {
+	//Запись результата попытки доставки события приемнику
+	_, err := store.database.ExecContext(ctx,
+		"INSERT INTO outbox_delivery (event, sink, attempts, next_attempt_at, delivered_at, dead_at, last_error)"+
+			" VALUES ($1, $2, $3, $4, $5, $6, $7)"+
+			" ON CONFLICT (event, sink) DO UPDATE"+
+			" SET attempts = EXCLUDED.attempts,"+
+			"     next_attempt_at = EXCLUDED.next_attempt_at,"+
+			"     delivered_at = EXCLUDED.delivered_at,"+
+			"     dead_at = EXCLUDED.dead_at,"+
+			"     last_error = EXCLUDED.last_error",
+		delivery.Event,
+		delivery.Sink,
+		delivery.Data.Attempts,
+		delivery.Data.NextAttemptAt,
+		sql.NullTime{Time: delivery.Data.DeliveredAt, Valid: !delivery.Data.DeliveredAt.IsZero()},
+		sql.NullTime{Time: delivery.Data.DeadAt, Valid: !delivery.Data.DeadAt.IsZero()},
+		delivery.Data.LastError)
+	return err
+}
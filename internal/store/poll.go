@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+)
+
+// Расписание опроса системы начислений хранится в purchase_order,
+// поэтому переживает перезапуск и делится между экземплярами сервиса
+
+func (store *store) PurchaseOrderClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]model.PollTask, error) {
+	//Захват заказов, которые пора опросить.
+	//next_poll_at сдвигается на время аренды: другие экземпляры не опросят заказ повторно,
+	//а если экземпляр упадет, заказ снова станет доступен после окончания аренды
+	rows, err := store.database.QueryContext(ctx,
+		"UPDATE purchase_order SET next_poll_at = $2"+
+			" WHERE number IN ("+
+			"  SELECT number FROM purchase_order"+
+			"  WHERE next_poll_at <= $1 AND status IN ($3, $4)"+
+			"  ORDER BY next_poll_at"+
+			"  LIMIT $5"+
+			"  FOR UPDATE SKIP LOCKED)"+
+			" RETURNING number, customer, status, accrual, uploaded_at, poll_attempts, poll_started_at",
+		now,
+		now.Add(lease),
+		model.PurchaseOrderStatusNew,
+		model.PurchaseOrderStatusProcessing,
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tasks []model.PollTask
+	for rows.Next() {
+		var task model.PollTask
+		err := rows.Scan(&task.Order.Number,
+			&task.Order.Data.Customer,
+			&task.Order.Data.Status,
+			&task.Order.Data.Accrual,
+			&task.Order.Data.UploadedAt,
+			&task.Attempts,
+			&task.StartedAt)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+func (store *store) PurchaseOrderSchedule(ctx context.Context, number string, attempts int, nextPollAt time.Time) error {
+	//Следующий опрос заказа. Нулевое время - опрос завершен
+	var next any
+	if !nextPollAt.IsZero() {
+		next = nextPollAt
+	}
+	_, err := store.database.ExecContext(ctx,
+		"UPDATE purchase_order SET poll_attempts = $2, next_poll_at = $3"+
+			" WHERE number = $1",
+		number,
+		attempts,
+		next)
+	return err
+}
+
+func (store *store) PurchaseOrderDeadLetter(ctx context.Context, number string, attempts int, at time.Time) error {
+	//Прекращение опроса заказа
+	_, err := store.database.ExecContext(ctx,
+		"UPDATE purchase_order SET poll_attempts = $2, next_poll_at = NULL, dead_lettered_at = $3"+
+			" WHERE number = $1",
+		number,
+		attempts,
+		at)
+	return err
+}
+
+func (store *store) PurchaseOrderRequeue(ctx context.Context, uploadedBefore time.Time, now time.Time) ([]model.PurchaseOrder, error) {
+	//Немедленный опрос необработанных заказов, загруженных раньше заданного момента,
+	//в том числе прекращенных (dead letter). Счетчик попыток и срок опроса начинаются заново
+	rows, err := store.database.QueryContext(ctx,
+		"UPDATE purchase_order SET next_poll_at = $3, poll_started_at = $3, poll_attempts = 0, dead_lettered_at = NULL"+
+			" WHERE status IN ($1, $2) AND uploaded_at < $4"+
+			" RETURNING number, customer, status, accrual, uploaded_at",
+		model.PurchaseOrderStatusNew,
+		model.PurchaseOrderStatusProcessing,
+		now,
+		uploadedBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var orders []model.PurchaseOrder
+	for rows.Next() {
+		var orderRow model.PurchaseOrder
+		err := rows.Scan(&orderRow.Number,
+			&orderRow.Data.Customer,
+			&orderRow.Data.Status,
+			&orderRow.Data.Accrual,
+			&orderRow.Data.UploadedAt)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, orderRow)
+	}
+	return orders, rows.Err()
+}
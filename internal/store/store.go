@@ -4,22 +4,71 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/iurnickita/gophermart/internal/model"
 	"github.com/iurnickita/gophermart/internal/store/config"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
 )
 
 type Store interface {
 	BalanceGetActual(ctx context.Context, customer string) (model.Balance, error)
 	BalanceGetWithdrawals(ctx context.Context, customer string) ([]model.Balance, error)
 	BalanceGetHistory(ctx context.Context, customer string) ([]model.Balance, error)
+	BalanceGetStatement(ctx context.Context, customer string, from time.Time, to time.Time, fn func(model.StatementEntry) error) error
 	BalanceIncrease(ctx context.Context, customer string, order string, points int) error
 	BalanceDecrease(ctx context.Context, customer string, order string, points int) error
+	BalanceReverse(ctx context.Context, customer string, operation string) (model.Balance, error)
+	BalanceGetOnHold(ctx context.Context, customer string) (int, error)
+	BalanceHoldPost(ctx context.Context, hold model.BalanceHold) (model.BalanceHold, error)
+	BalanceHoldCapture(ctx context.Context, id string) (model.Balance, error)
+	BalanceHoldRelease(ctx context.Context, id string) error
+	BalanceHoldExpire(ctx context.Context) (int, error)
+	PurchaseOrderGetAccrued(ctx context.Context, customer string) (int, error)
+	BalanceCorrect(ctx context.Context, correction model.Balance, lastOperation string) (string, error)
+	BalanceGetCustomers(ctx context.Context) ([]string, error)
 	PurchaseOrderPost(ctx context.Context, order model.PurchaseOrder) error
+	PurchaseOrderPostBatch(ctx context.Context, orders []model.PurchaseOrder) ([]error, error)
 	PurchaseOrderPut(ctx context.Context, order model.PurchaseOrder) error
+	PurchaseOrderComplete(ctx context.Context, order model.PurchaseOrder, policy model.ReferralPolicy) (bool, error)
 	PurchaseOrderGet(ctx context.Context, customer string) ([]model.PurchaseOrder, error)
+	PurchaseOrderGetByNumber(ctx context.Context, number string) (model.PurchaseOrder, error)
+	PurchaseOrderClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]model.PollTask, error)
+	PurchaseOrderSchedule(ctx context.Context, number string, attempts int, nextPollAt time.Time) error
+	PurchaseOrderDeadLetter(ctx context.Context, number string, attempts int, at time.Time) error
+	PurchaseOrderRequeue(ctx context.Context, uploadedBefore time.Time, now time.Time) ([]model.PurchaseOrder, error)
+	OutboxClaimPending(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]model.Event, error)
+	OutboxMarkDelivered(ctx context.Context, id string) error
+	OutboxMarkFailed(ctx context.Context, id string, nextAttemptAt time.Time, lastError string) error
+	OutboxMarkDead(ctx context.Context, id string, attempts int, lastError string) error
+	OutboxRelease(ctx context.Context, id string, now time.Time) error
+	OutboxDeliveryGet(ctx context.Context, event string) ([]model.EventDelivery, error)
+	OutboxDeliveryPut(ctx context.Context, delivery model.EventDelivery) error
+	CustomerPost(ctx context.Context, customer model.Customer, referrer string) error
+	CustomerGet(ctx context.Context, code string) (model.Customer, error)
+	CustomerGetByLogin(ctx context.Context, login string) (model.Customer, error)
+	CustomerGetByReferralCode(ctx context.Context, referralCode string) (model.Customer, error)
+	CustomerFind(ctx context.Context, filter model.CustomerFilter) ([]model.Customer, error)
+	AdminUserPost(ctx context.Context, admin model.AdminUser) error
+	AdminUserGet(ctx context.Context) ([]model.AdminUser, error)
+	AdminUserGetByTokenHash(ctx context.Context, tokenHash string) (model.AdminUser, error)
+	ReferralGet(ctx context.Context, referrer string) ([]model.Referral, error)
+	ReferralGetByReferee(ctx context.Context, referee string) (model.Referral, error)
+	TierGet(ctx context.Context, customer string) (model.CustomerTier, error)
+	TierPut(ctx context.Context, customerTier model.CustomerTier) error
+	WebhookPost(ctx context.Context, webhook model.Webhook, maxPerCustomer int) (string, error)
+	WebhookGet(ctx context.Context, customer string) ([]model.Webhook, error)
+	WebhookDelete(ctx context.Context, customer string, id string) error
+	AuditPost(ctx context.Context, event model.AuditEvent) error
+	AuditGet(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error)
+	Ping(ctx context.Context) error
+	SchemaVersion(ctx context.Context) (int, error)
+	WebhookDeliveryPost(ctx context.Context, delivery model.WebhookDelivery) error
+	WebhookDeliveryGet(ctx context.Context, customer string, webhook string) ([]model.WebhookDelivery, error)
+	WebhookDeliverySucceeded(ctx context.Context, webhook string, event string, order string) (bool, error)
 }
 
 var (
@@ -27,73 +76,143 @@ var (
 	ErrDuplicateRequest  = errors.New("duplicate request")
 	ErrPointsIncorrect   = errors.New("points value is incorrect")
 	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrConcurrentUpdate  = errors.New("balance was changed concurrently")
+	ErrNotFound          = errors.New("not found")
+	ErrAlreadyReversed   = errors.New("operation already reversed")
+	ErrHoldNotActive     = errors.New("hold is not active")
+	ErrLimitExceeded     = errors.New("limit exceeded")
+	ErrCodeCollision     = errors.New("generated code already in use")
 )
 
+// uniqueViolation - код ошибки PostgreSQL при нарушении уникальности
+const uniqueViolation = "23505"
+
 type store struct {
-	database     *sql.DB
+	database     *tracedDB
+	mutex        sync.Mutex
 	balanceMutex map[string]*sync.Mutex
 }
 
-func NewStore(cfg config.Config) (Store, error) {
+func NewStore(cfg config.Config, zaplog *zap.Logger) (Store, error) {
 	db, err := sql.Open("pgx", cfg.DBDsn)
 	if err != nil {
 		return nil, err
 	}
 
-	// Таблица баланса пользователя
-	// Представлят собой журнал. Для каждой новой операции пользователя создается новая запись,
-	// так легче отслеживать историю и выявлять ошибки при операциях с балансом
-	// [не реализовано] Блокировка на уровне пользователя *костыль: store.balanceMutex[customer]mutex
-	// [не реализовано] Записи нельзя редактировать/удалять
-	_, err = db.Exec(
-		"CREATE TABLE IF NOT EXISTS balance (" +
-			" customer VARCHAR (10) PRIMARY KEY," +
-			" operation SERIAL PRIMARY KEY," +
-			" timestamp TIMESTAMP NOT NULL," +
-			" difference INTEGER NOT NULL," +
-			" balance INTEGER," +
-			" withdrawn INTEGER," +
-			" order VARCHAR (10) NOT NULL" +
-			" );")
+	err = migrate(context.Background(), db)
 	if err != nil {
 		return nil, err
 	}
 
-	// Таблица заказов.
-	// Создается одна строка на заказ, после чего меняется ее статус
-	_, err = db.Exec(
-		"CREATE TABLE IF NOT EXISTS purchase_order (" +
-			" number VARCHAR (10) PRIMARY KEY," +
-			" customer VARCHAR (10) NOT NULL," +
-			" status VARCHAR (10) NOT NULL," +
-			" accrual INTEGER NOT NULL," +
-			" uploaded_at TIMESTAMP NOT NULL," +
-			" );")
+	return &store{
+		database:     &tracedDB{DB: db, zaplog: zaplog},
+		balanceMutex: make(map[string]*sync.Mutex),
+	}, nil
+}
+
+// Ping проверяет соединение с БД
+func (store *store) Ping(ctx context.Context) error {
+	return store.database.PingContext(ctx)
+}
+
+// customerMutex возвращает мьютекс баланса пользователя, создавая его при первом обращении
+func (store *store) customerMutex(customer string) *sync.Mutex {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	mutex, ok := store.balanceMutex[customer]
+	if !ok {
+		mutex = &sync.Mutex{}
+		store.balanceMutex[customer] = mutex
+	}
+	return mutex
+}
+
+// lockCustomers блокирует балансы пользователей в этом экземпляре сервиса в постоянном порядке,
+// возвращает функцию снятия блокировки
+func (store *store) lockCustomers(customers ...string) func() {
+	customers = slices.Clone(customers)
+	slices.Sort(customers)
+	customers = slices.Compact(customers)
+	for _, customer := range customers {
+		store.customerMutex(customer).Lock()
+	}
+	return func() {
+		for _, customer := range customers {
+			store.customerMutex(customer).Unlock()
+		}
+	}
+}
+
+// lockBalance блокирует журналы баланса пользователей до конца транзакции.
+// В отличие от customerMutex, блокировка действует для всех экземпляров сервиса.
+// Пользователи блокируются в постоянном порядке, чтобы исключить взаимоблокировку
+func (store *store) lockBalance(ctx context.Context, tx *tracedTx, customers ...string) error {
+	customers = slices.Clone(customers)
+	slices.Sort(customers)
+	for _, customer := range slices.Compact(customers) {
+		_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('balance'), hashtext($1))", customer)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// querier - общий интерфейс *sql.DB и *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// inTx выполняет fn в транзакции. При ошибке транзакция откатывается.
+func (store *store) inTx(ctx context.Context, fn func(tx *tracedTx) error) error {
+	tx, err := store.database.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
 
-	return &store{
-		database: db,
-	}, nil
+const balanceColumns = "customer, operation, timestamp, kind, difference, balance, withdrawn, \"order\", reference"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBalance(row rowScanner, balanceRow *model.Balance) error {
+	return row.Scan(&balanceRow.Key.Customer,
+		&balanceRow.Key.Operation,
+		&balanceRow.Data.Timestamp,
+		&balanceRow.Data.Kind,
+		&balanceRow.Data.Difference,
+		&balanceRow.Data.Balance,
+		&balanceRow.Data.Withdrawn,
+		&balanceRow.Data.Order,
+		&balanceRow.Data.Reference)
 }
 
 func (store *store) BalanceGetActual(ctx context.Context, customer string) (model.Balance, error) {
+	return store.balanceGetActual(ctx, store.database, customer)
+}
+
+func (store *store) balanceGetActual(ctx context.Context, q querier, customer string) (model.Balance, error) {
 	//Получение актуального баланса
 	var balanceRow model.Balance
-	row := store.database.QueryRowContext(ctx,
-		"SELECT customer, operation, timestamp, difference, balance, withdrawn, order"+
-			" FROM balance ORDER BY operation"+
+	row := q.QueryRowContext(ctx,
+		"SELECT "+balanceColumns+
+			" FROM balance"+
 			" WHERE customer = $1"+
+			" ORDER BY operation DESC"+
 			" LIMIT 1",
 		customer)
-	err := row.Scan(&balanceRow.Key.Customer,
-		&balanceRow.Key.Operation,
-		&balanceRow.Data.Timestamp,
-		&balanceRow.Data.Difference,
-		&balanceRow.Data.Balance,
-		&balanceRow.Data.Withdrawn,
-		&balanceRow.Data.Order)
+	err := scanBalance(row, &balanceRow)
 	if err != nil && err != sql.ErrNoRows { // если нет строки - ок
 		return model.Balance{}, err
 	}
@@ -101,14 +220,18 @@ func (store *store) BalanceGetActual(ctx context.Context, customer string) (mode
 }
 
 func (store *store) BalanceGetWithdrawals(ctx context.Context, customer string) ([]model.Balance, error) {
-	//Получение списаний
+	//Получение списаний с признаком отмены
 	rows, err := store.database.QueryContext(ctx,
-		"SELECT customer, operation, timestamp, difference, balance, withdrawn, order"+
-			" FROM balance ORDER BY operation"+
+		"SELECT "+balanceColumns+","+
+			"       EXISTS (SELECT 1 FROM balance AS r"+
+			"                WHERE r.kind = $3"+
+			"                  AND r.reference = CAST(balance.operation AS VARCHAR))"+
+			" FROM balance"+
 			" WHERE customer = $1"+
+			"   AND kind IN ('', $2)"+
 			"   AND difference < 0"+
-			" LIMIT 1",
-		customer)
+			" ORDER BY operation",
+		customer, model.BalanceKindWithdrawal, model.BalanceKindReversal)
 	if err != nil {
 		return nil, err
 	}
@@ -119,26 +242,131 @@ func (store *store) BalanceGetWithdrawals(ctx context.Context, customer string)
 		err := rows.Scan(&balanceRow.Key.Customer,
 			&balanceRow.Key.Operation,
 			&balanceRow.Data.Timestamp,
+			&balanceRow.Data.Kind,
 			&balanceRow.Data.Difference,
 			&balanceRow.Data.Balance,
 			&balanceRow.Data.Withdrawn,
-			&balanceRow.Data.Order)
+			&balanceRow.Data.Order,
+			&balanceRow.Data.Reference,
+			&balanceRow.Data.Reversed)
 		if err != nil {
 			return nil, err
 		}
 		withdrawals = append(withdrawals, balanceRow)
 	}
 
-	return withdrawals, nil
+	return withdrawals, rows.Err()
 }
 
 func (store *store) BalanceGetHistory(ctx context.Context, customer string) ([]model.Balance, error) {
-	return nil, nil
+	//Получение всего журнала пользователя в порядке операций
+	rows, err := store.database.QueryContext(ctx,
+		"SELECT "+balanceColumns+
+			" FROM balance"+
+			" WHERE customer = $1"+
+			" ORDER BY operation",
+		customer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var history []model.Balance
+	for rows.Next() {
+		var balanceRow model.Balance
+		err := scanBalance(rows, &balanceRow)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, balanceRow)
+	}
+
+	return history, rows.Err()
+}
+
+// BalanceGetStatement передает в fn операции пользователя за период [from, to) по одной,
+// не загружая выписку в память целиком. Первой передается последняя операция до from
+// (Opening = true), если она есть. Выборка выполняется одним запросом, поэтому
+// входящий остаток и операции согласованы между собой
+func (store *store) BalanceGetStatement(ctx context.Context, customer string, from time.Time, to time.Time, fn func(model.StatementEntry) error) error {
+	//Получение журнала за период с заказами
+	rows, err := store.database.QueryContext(ctx,
+		"SELECT opening, b.customer, b.operation, b.timestamp, b.kind, b.difference, b.balance, b.withdrawn,"+
+			" b.\"order\", b.reference,"+
+			" COALESCE(p.number, ''), COALESCE(p.status, ''), COALESCE(p.accrual, 0), COALESCE(p.uploaded_at, 'epoch')"+
+			" FROM ("+
+			"  (SELECT true AS opening, * FROM balance"+
+			"   WHERE customer = $1 AND timestamp < $2"+
+			"   ORDER BY operation DESC LIMIT 1)"+
+			"  UNION ALL"+
+			"  (SELECT false AS opening, * FROM balance"+
+			"   WHERE customer = $1 AND timestamp >= $2 AND timestamp < $3)"+
+			" ) b"+
+			" LEFT JOIN purchase_order p ON p.number = b.\"order\" AND p.customer = b.customer"+
+			" ORDER BY opening DESC, b.operation",
+		customer, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry model.StatementEntry
+		err := rows.Scan(&entry.Opening,
+			&entry.Entry.Key.Customer,
+			&entry.Entry.Key.Operation,
+			&entry.Entry.Data.Timestamp,
+			&entry.Entry.Data.Kind,
+			&entry.Entry.Data.Difference,
+			&entry.Entry.Data.Balance,
+			&entry.Entry.Data.Withdrawn,
+			&entry.Entry.Data.Order,
+			&entry.Entry.Data.Reference,
+			&entry.Order.Number,
+			&entry.Order.Data.Status,
+			&entry.Order.Data.Accrual,
+			&entry.Order.Data.UploadedAt)
+		if err != nil {
+			return err
+		}
+		if entry.Order.Number != "" {
+			entry.Order.Data.Customer = customer
+		} else {
+			entry.Order = model.PurchaseOrder{}
+		}
+		err = fn(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (store *store) BalanceGetCustomers(ctx context.Context) ([]string, error) {
+	//Получение всех пользователей, у которых есть журнал или заказы
+	rows, err := store.database.QueryContext(ctx,
+		"SELECT customer FROM balance"+
+			" UNION"+
+			" SELECT customer FROM purchase_order"+
+			" ORDER BY customer")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var customers []string
+	for rows.Next() {
+		var customer string
+		err := rows.Scan(&customer)
+		if err != nil {
+			return nil, err
+		}
+		customers = append(customers, customer)
+	}
+
+	return customers, rows.Err()
 }
 
-func (store store) BalanceIncrease(ctx context.Context, customer string, order string, points int) error {
+func (store *store) BalanceIncrease(ctx context.Context, customer string, order string, points int) error {
 	//Блокировка баланса пользователя
-	mutex := store.balanceMutex[customer]
+	mutex := store.customerMutex(customer)
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -146,38 +374,52 @@ func (store store) BalanceIncrease(ctx context.Context, customer string, order s
 		return ErrPointsIncorrect
 	}
 
+	return store.inTx(ctx, func(tx *tracedTx) error {
+		err := store.lockBalance(ctx, tx, customer)
+		if err != nil {
+			return err
+		}
+		_, err = store.balanceCredit(ctx, tx, customer, order, model.BalanceKindAccrual, points, "")
+		if err != nil {
+			return err
+		}
+
+		return store.outboxInsert(ctx, tx, model.EventData{
+			Type:     model.EventPointsAccrued,
+			Customer: customer,
+			Order:    order,
+			Points:   points})
+	})
+}
+
+// balanceCredit записывает зачисление баллов вида kind.
+// Вызывается под блокировкой баланса пользователя.
+func (store *store) balanceCredit(ctx context.Context, tx *tracedTx, customer string, order string, kind string, points int, reference string) (model.Balance, error) {
 	//Получение актуального баланса
-	balanceRow, err := store.BalanceGetActual(ctx, customer)
+	balanceRow, err := store.balanceGetActual(ctx, tx, customer)
 	if err != nil {
-		return err
+		return model.Balance{}, err
 	}
 
 	//Запись обновленного баланса
 	balanceRow.Key.Customer = customer
 	balanceRow.Data.Timestamp = time.Now()
+	balanceRow.Data.Kind = kind
 	balanceRow.Data.Difference = points
-	balanceRow.Data.Balance += points
-	//balanceRow.Data.Withdrawn
+	balanceRow.Data.Balance, balanceRow.Data.Withdrawn = model.BalanceTotals(
+		balanceRow.Data.Balance, balanceRow.Data.Withdrawn, balanceRow.Data.Kind, points)
 	balanceRow.Data.Order = order
-	_, err = store.database.ExecContext(ctx,
-		"INSERT INTO balance (customer, timestamp, difference, balance, withdrawn, order)"+
-			" VALUES (&1, &2, &3, &4, &5, &6)",
-		balanceRow.Key.Customer,
-		balanceRow.Data.Timestamp,
-		balanceRow.Data.Difference,
-		balanceRow.Data.Balance,
-		balanceRow.Data.Withdrawn,
-		balanceRow.Data.Order)
+	balanceRow.Data.Reference = reference
+	balanceRow.Key.Operation, err = store.balanceInsert(ctx, tx, balanceRow)
 	if err != nil {
-		return err
+		return model.Balance{}, err
 	}
-
-	return nil
+	return balanceRow, nil
 }
 
 func (store *store) BalanceDecrease(ctx context.Context, customer string, order string, points int) error {
 	//Блокировка баланса пользователя
-	mutex := store.balanceMutex[customer]
+	mutex := store.customerMutex(customer)
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -185,94 +427,406 @@ func (store *store) BalanceDecrease(ctx context.Context, customer string, order
 		return ErrPointsIncorrect
 	}
 
+	return store.inTx(ctx, func(tx *tracedTx) error {
+		err := store.lockBalance(ctx, tx, customer)
+		if err != nil {
+			return err
+		}
+		//Баллы, зарезервированные активными холдами, списать нельзя
+		onHold, err := store.balanceGetOnHold(ctx, tx, customer)
+		if err != nil {
+			return err
+		}
+
+		_, err = store.balanceWithdraw(ctx, tx, customer, order, points, onHold)
+		return err
+	})
+}
+
+// balanceWithdraw записывает списание, если после него на балансе останется не меньше reserved баллов.
+// Вызывается под блокировкой баланса пользователя.
+func (store *store) balanceWithdraw(ctx context.Context, tx *tracedTx, customer string, order string, points int, reserved int) (model.Balance, error) {
 	//Получение актуального баланса
-	var balanceRow model.Balance
-	row := store.database.QueryRowContext(ctx,
-		"SELECT customer, operation, timestamp, difference, balance, withdrawn, order"+
-			" FROM balance ORDER BY operation"+
-			" WHERE customer = $1"+
-			" LIMIT 1",
-		customer)
-	err := row.Scan(&balanceRow.Key.Customer,
-		&balanceRow.Key.Operation,
-		&balanceRow.Data.Timestamp,
-		&balanceRow.Data.Difference,
-		&balanceRow.Data.Balance,
-		&balanceRow.Data.Withdrawn,
-		&balanceRow.Data.Order)
+	balanceRow, err := store.balanceGetActual(ctx, tx, customer)
 	if err != nil {
-		return err
+		return model.Balance{}, err
 	}
 
 	//Проверка достаточно средств
-	if balanceRow.Data.Balance < points {
-		return ErrInsufficientFunds
+	if balanceRow.Data.Balance-reserved < points {
+		return model.Balance{}, ErrInsufficientFunds
 	}
 
 	//Запись обновленного баланса
 	balanceRow.Key.Customer = customer
 	balanceRow.Data.Timestamp = time.Now()
+	balanceRow.Data.Kind = model.BalanceKindWithdrawal
 	balanceRow.Data.Difference = -points
-	balanceRow.Data.Balance -= points
-	balanceRow.Data.Withdrawn += points
+	balanceRow.Data.Balance, balanceRow.Data.Withdrawn = model.BalanceTotals(
+		balanceRow.Data.Balance, balanceRow.Data.Withdrawn, balanceRow.Data.Kind, -points)
 	balanceRow.Data.Order = order
-	_, err = store.database.ExecContext(ctx,
-		"INSERT INTO balance (customer, timestamp, difference, balance, withdrawn, order)"+
-			" VALUES (&1, &2, &3, &4, &5, &6)",
+	balanceRow.Data.Reference = ""
+	balanceRow.Key.Operation, err = store.balanceInsert(ctx, tx, balanceRow)
+	if err != nil {
+		return model.Balance{}, err
+	}
+
+	err = store.outboxInsert(ctx, tx, model.EventData{
+		Type:     model.EventPointsWithdrawn,
+		Customer: customer,
+		Order:    order,
+		Points:   points})
+	if err != nil {
+		return model.Balance{}, err
+	}
+	return balanceRow, nil
+}
+
+// BalanceReverse отменяет списание operation: возвращает пользователю ровно списанную сумму.
+// Каждое списание отменяется не более одного раза.
+func (store *store) BalanceReverse(ctx context.Context, customer string, operation string) (model.Balance, error) {
+	//Блокировка баланса пользователя
+	mutex := store.customerMutex(customer)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var reversal model.Balance
+	err := store.inTx(ctx, func(tx *tracedTx) error {
+		err := store.lockBalance(ctx, tx, customer)
+		if err != nil {
+			return err
+		}
+		//Исходное списание
+		var original model.Balance
+		row := tx.QueryRowContext(ctx,
+			"SELECT "+balanceColumns+
+				" FROM balance"+
+				" WHERE customer = $1"+
+				"   AND operation = $2",
+			customer, operation)
+		err = scanBalance(row, &original)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+		if original.Data.Difference >= 0 ||
+			(original.Data.Kind != model.BalanceKindWithdrawal && original.Data.Kind != "") {
+			return ErrNotFound
+		}
+
+		//Проверка, что списание еще не отменено
+		var reversed bool
+		row = tx.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM balance"+
+				" WHERE kind = $1"+
+				"   AND reference = $2)",
+			model.BalanceKindReversal, operation)
+		err = row.Scan(&reversed)
+		if err != nil {
+			return err
+		}
+		if reversed {
+			return ErrAlreadyReversed
+		}
+
+		//Получение актуального баланса
+		balanceRow, err := store.balanceGetActual(ctx, tx, customer)
+		if err != nil {
+			return err
+		}
+
+		//Запись отмены
+		points := -original.Data.Difference
+		balanceRow.Key.Customer = customer
+		balanceRow.Data.Timestamp = time.Now()
+		balanceRow.Data.Kind = model.BalanceKindReversal
+		balanceRow.Data.Difference = points
+		balanceRow.Data.Balance, balanceRow.Data.Withdrawn = model.BalanceTotals(
+			balanceRow.Data.Balance, balanceRow.Data.Withdrawn, balanceRow.Data.Kind, points)
+		balanceRow.Data.Order = original.Data.Order
+		balanceRow.Data.Reference = original.Key.Operation
+		balanceRow.Key.Operation, err = store.balanceInsert(ctx, tx, balanceRow)
+		if err != nil {
+			return err
+		}
+		reversal = balanceRow
+
+		return store.outboxInsert(ctx, tx, model.EventData{
+			Type:     model.EventPointsReversed,
+			Customer: customer,
+			Order:    original.Data.Order,
+			Points:   points})
+	})
+	if err != nil {
+		return model.Balance{}, err
+	}
+	return reversal, nil
+}
+
+// BalanceCorrect записывает корректирующую операцию с заранее рассчитанными итогами.
+// Запись выполняется, только если последняя операция пользователя все еще lastOperation,
+// иначе возвращается ErrConcurrentUpdate. Проверка и запись выполняются в одной транзакции
+// под блокировкой баланса, поэтому операция другого экземпляра сервиса между ними невозможна.
+func (store *store) BalanceCorrect(ctx context.Context, correction model.Balance, lastOperation string) (string, error) {
+	//Блокировка баланса пользователя
+	mutex := store.customerMutex(correction.Key.Customer)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var operation string
+	err := store.inTx(ctx, func(tx *tracedTx) error {
+		err := store.lockBalance(ctx, tx, correction.Key.Customer)
+		if err != nil {
+			return err
+		}
+
+		//Проверка, что журнал не изменился с момента сверки
+		balanceRow, err := store.balanceGetActual(ctx, tx, correction.Key.Customer)
+		if err != nil {
+			return err
+		}
+		if balanceRow.Key.Operation != lastOperation {
+			return ErrConcurrentUpdate
+		}
+
+		correction.Data.Timestamp = time.Now()
+		correction.Data.Kind = model.BalanceKindCorrection
+		operation, err = store.balanceInsert(ctx, tx, correction)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return operation, nil
+}
+
+func (store *store) balanceInsert(ctx context.Context, q querier, balanceRow model.Balance) (string, error) {
+	//Запись операции в журнал
+	var operation string
+	row := q.QueryRowContext(ctx,
+		"INSERT INTO balance (customer, timestamp, kind, difference, balance, withdrawn, \"order\", reference)"+
+			" VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"+
+			" RETURNING operation",
 		balanceRow.Key.Customer,
 		balanceRow.Data.Timestamp,
+		balanceRow.Data.Kind,
 		balanceRow.Data.Difference,
 		balanceRow.Data.Balance,
 		balanceRow.Data.Withdrawn,
-		balanceRow.Data.Order)
+		balanceRow.Data.Order,
+		balanceRow.Data.Reference)
+	err := row.Scan(&operation)
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	return nil
+	return operation, nil
 }
 
 func (store *store) PurchaseOrderPost(ctx context.Context, order model.PurchaseOrder) error {
+	return store.inTx(ctx, func(tx *tracedTx) error {
+		return store.purchaseOrderInsert(ctx, tx, order)
+	})
+}
+
+// PurchaseOrderPostBatch записывает заказы в одной транзакции.
+// Для каждого заказа возвращается nil, ErrDuplicateRequest или ErrAlreadyExists,
+// прочие ошибки откатывают транзакцию целиком.
+func (store *store) PurchaseOrderPostBatch(ctx context.Context, orders []model.PurchaseOrder) ([]error, error) {
+	results := make([]error, len(orders))
+	err := store.inTx(ctx, func(tx *tracedTx) error {
+		for i, order := range orders {
+			err := store.purchaseOrderInsert(ctx, tx, order)
+			switch err {
+			case nil, ErrDuplicateRequest, ErrAlreadyExists:
+				results[i] = err
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (store *store) purchaseOrderInsert(ctx context.Context, tx *tracedTx, order model.PurchaseOrder) error {
 	//Запись нового заказа
-	_, err := store.database.ExecContext(ctx,
-		"INSERT INTO purchase_order (number, customer, status, accrual, uploaded_at)"+
-			" VALUES (&1, &2, &3, &4, &5)",
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO purchase_order (number, customer, status, accrual, uploaded_at, next_poll_at, poll_started_at)"+
+			" VALUES ($1, $2, $3, $4, $5, $5, $5)"+
+			" ON CONFLICT (number) DO NOTHING",
 		order.Number,
 		order.Data.Customer,
 		order.Data.Status,
 		order.Data.Accrual,
 		order.Data.UploadedAt)
 	if err != nil {
-		row := store.database.QueryRowContext(ctx,
+		return err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		//Заказ уже загружен: этим же пользователем или другим
+		row := tx.QueryRowContext(ctx,
 			"SELECT customer FROM purchase_order"+
 				" WHERE number = $1",
 			order.Number)
 		var customer string
 		err = row.Scan(&customer)
 		if err == nil {
-			if customer != order.Data.Customer {
+			if customer == order.Data.Customer {
 				return ErrDuplicateRequest
 			}
 		}
 		return ErrAlreadyExists
 	}
-	return nil
+
+	return store.outboxInsert(ctx, tx, model.EventData{
+		Type:     model.EventOrderRegistered,
+		Customer: order.Data.Customer,
+		Order:    order.Number,
+		Status:   order.Data.Status})
 }
 
 func (store *store) PurchaseOrderPut(ctx context.Context, order model.PurchaseOrder) error {
-	//Обновление статуса заказа
-	_, err := store.database.ExecContext(ctx,
-		"UPDATE purchase_order AS o"+
-			" SET status = $1"+
-			" WHERE number = $2"+
-			"   AND customer = $3",
-		order.Data.Status,
-		order.Number,
-		order.Data.Customer)
+	return store.inTx(ctx, func(tx *tracedTx) error {
+		//Обновление статуса необработанного заказа.
+		//Событие создается, только если статус действительно изменился
+		result, err := tx.ExecContext(ctx,
+			"UPDATE purchase_order"+
+				" SET status = $1,"+
+				"     accrual = $4"+
+				" WHERE number = $2"+
+				"   AND customer = $3"+
+				"   AND (status <> $1 OR accrual <> $4)"+
+				"   AND status NOT IN ($5, $6)",
+			order.Data.Status,
+			order.Number,
+			order.Data.Customer,
+			order.Data.Accrual,
+			model.PurchaseOrderStatusInvalid,
+			model.PurchaseOrderStatusProcessed)
+		if err != nil {
+			return err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return nil
+		}
+
+		return store.outboxInsert(ctx, tx, model.EventData{
+			Type:     model.EventOrderStatusChanged,
+			Customer: order.Data.Customer,
+			Order:    order.Number,
+			Status:   order.Data.Status,
+			Points:   order.Data.Accrual})
+	})
+}
+
+// PurchaseOrderComplete переводит необработанный заказ в конечный статус (PROCESSED или INVALID)
+// и в той же транзакции начисляет order.Data.Accrual баллов и записывает события.
+// Заказ, уже находящийся в конечном статусе, не меняется и ничего не начисляется:
+// возвращается false. Поэтому ответ системы начислений можно применять повторно.
+// Если заказ приглашенного пользователя обработан первым, в той же транзакции по условиям policy
+// выплачиваются бонусы по приглашению
+func (store *store) PurchaseOrderComplete(ctx context.Context, order model.PurchaseOrder, policy model.ReferralPolicy) (bool, error) {
+	if order.Data.Accrual < 0 {
+		return false, ErrPointsIncorrect
+	}
+
+	//Пригласивший пользователя: его баланс блокируется вместе с балансом пользователя
+	//на случай выплаты бонуса. Приглашение записывается при регистрации и не меняется
+	customers := []string{order.Data.Customer}
+	invite, err := store.ReferralGetByReferee(ctx, order.Data.Customer)
+	switch err {
+	case nil:
+		customers = append(customers, invite.Data.Referrer)
+	case ErrNotFound:
+	default:
+		return false, err
+	}
+
+	//Блокировка балансов пользователей
+	defer store.lockCustomers(customers...)()
+
+	completed := false
+	err = store.inTx(ctx, func(tx *tracedTx) error {
+		err := store.lockBalance(ctx, tx, customers...)
+		if err != nil {
+			return err
+		}
+		//Смена статуса, только если заказ еще не в конечном статусе
+		result, err := tx.ExecContext(ctx,
+			"UPDATE purchase_order"+
+				" SET status = $1,"+
+				"     accrual = $2,"+
+				"     base_accrual = $7,"+
+				"     next_poll_at = NULL"+
+				" WHERE number = $3"+
+				"   AND customer = $4"+
+				"   AND status NOT IN ($5, $6)",
+			order.Data.Status,
+			order.Data.Accrual,
+			order.Number,
+			order.Data.Customer,
+			model.PurchaseOrderStatusInvalid,
+			model.PurchaseOrderStatusProcessed,
+			order.Data.BaseAccrual)
+		if err != nil {
+			return err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return nil
+		}
+		completed = true
+
+		err = store.outboxInsert(ctx, tx, model.EventData{
+			Type:     model.EventOrderStatusChanged,
+			Customer: order.Data.Customer,
+			Order:    order.Number,
+			Status:   order.Data.Status,
+			Points:   order.Data.Accrual})
+		if err != nil {
+			return err
+		}
+		if order.Data.Accrual > 0 {
+			//Начисление баллов за заказ
+			_, err = store.balanceCredit(ctx, tx, order.Data.Customer, order.Number, model.BalanceKindAccrual, order.Data.Accrual, "")
+			if err != nil {
+				return err
+			}
+			err = store.outboxInsert(ctx, tx, model.EventData{
+				Type:     model.EventPointsAccrued,
+				Customer: order.Data.Customer,
+				Order:    order.Number,
+				Points:   order.Data.Accrual})
+			if err != nil {
+				return err
+			}
+		}
+
+		if order.Data.Status != model.PurchaseOrderStatusProcessed || invite.Referee == "" {
+			return nil
+		}
+		return store.referralReward(ctx, tx, invite, order, policy)
+	})
 	if err != nil {
-		return err
+		return false, err
 	}
-	return nil
+	return completed, nil
 }
 
 func (store *store) PurchaseOrderGet(ctx context.Context, customer string) ([]model.PurchaseOrder, error) {
@@ -302,3 +856,25 @@ func (store *store) PurchaseOrderGet(ctx context.Context, customer string) ([]mo
 
 	return orders, nil
 }
+
+func (store *store) PurchaseOrderGetByNumber(ctx context.Context, number string) (model.PurchaseOrder, error) {
+	//Получение заказа по номеру
+	var orderRow model.PurchaseOrder
+	row := store.database.QueryRowContext(ctx,
+		"SELECT number, customer, status, accrual, uploaded_at"+
+			" FROM purchase_order"+
+			" WHERE number = $1",
+		number)
+	err := row.Scan(&orderRow.Number,
+		&orderRow.Data.Customer,
+		&orderRow.Data.Status,
+		&orderRow.Data.Accrual,
+		&orderRow.Data.UploadedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return model.PurchaseOrder{}, ErrNotFound
+		}
+		return model.PurchaseOrder{}, err
+	}
+	return orderRow, nil
+}
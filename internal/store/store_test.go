@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/store/config"
+	"go.uber.org/zap"
+)
+
+// Тесты хранилища выполняются на настоящей PostgreSQL: строка подключения в TEST_DATABASE_URI.
+// Без нее тесты пропускаются
+func newTestStore(t *testing.T) Store {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI not set")
+	}
+	store, err := NewStore(config.Config{DBDsn: dsn}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	return store
+}
+
+// uniqueSuffix - уникальная для запуска часть номеров и кодов, чтобы тесты не мешали друг другу в общей БД
+func uniqueSuffix() string {
+	return fmt.Sprintf("%d", time.Now().UnixNano())
+}
+
+func TestPurchaseOrderPutAndComplete(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	suffix := uniqueSuffix()
+	customer := suffix[len(suffix)-10:]
+
+	tests := []struct {
+		name        string
+		status      string
+		accrual     int
+		baseAccrual int
+	}{
+		{name: "processed", status: model.PurchaseOrderStatusProcessed, accrual: 110, baseAccrual: 100},
+		{name: "invalid", status: model.PurchaseOrderStatusInvalid},
+	}
+	wantAccrued := 0
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := model.PurchaseOrder{
+				Number: fmt.Sprintf("%s%d", suffix, i),
+				Data: model.PurchaseOrderData{
+					Customer:   customer,
+					Status:     model.PurchaseOrderStatusNew,
+					UploadedAt: time.Now()}}
+			err := store.PurchaseOrderPost(ctx, order)
+			if err != nil {
+				t.Fatalf("PurchaseOrderPost: %v", err)
+			}
+
+			order.Data.Status = model.PurchaseOrderStatusProcessing
+			err = store.PurchaseOrderPut(ctx, order)
+			if err != nil {
+				t.Fatalf("PurchaseOrderPut: %v", err)
+			}
+			got, err := store.PurchaseOrderGetByNumber(ctx, order.Number)
+			if err != nil {
+				t.Fatalf("PurchaseOrderGetByNumber: %v", err)
+			}
+			if got.Data.Status != model.PurchaseOrderStatusProcessing {
+				t.Fatalf("status after put = %s, want %s", got.Data.Status, model.PurchaseOrderStatusProcessing)
+			}
+
+			order.Data.Status = tt.status
+			order.Data.Accrual = tt.accrual
+			order.Data.BaseAccrual = tt.baseAccrual
+			completed, err := store.PurchaseOrderComplete(ctx, order, model.ReferralPolicy{})
+			if err != nil {
+				t.Fatalf("PurchaseOrderComplete: %v", err)
+			}
+			if !completed {
+				t.Fatal("PurchaseOrderComplete = false, want true")
+			}
+			// повторный ответ системы начислений ничего не меняет
+			completed, err = store.PurchaseOrderComplete(ctx, order, model.ReferralPolicy{})
+			if err != nil {
+				t.Fatalf("repeated PurchaseOrderComplete: %v", err)
+			}
+			if completed {
+				t.Fatal("repeated PurchaseOrderComplete = true, want false")
+			}
+
+			got, err = store.PurchaseOrderGetByNumber(ctx, order.Number)
+			if err != nil {
+				t.Fatalf("PurchaseOrderGetByNumber: %v", err)
+			}
+			if got.Data.Status != tt.status || got.Data.Accrual != tt.accrual {
+				t.Fatalf("order = %s/%d, want %s/%d", got.Data.Status, got.Data.Accrual, tt.status, tt.accrual)
+			}
+
+			if tt.status == model.PurchaseOrderStatusProcessed {
+				wantAccrued += tt.baseAccrual
+			}
+			accrued, err := store.PurchaseOrderGetAccrued(ctx, customer)
+			if err != nil {
+				t.Fatalf("PurchaseOrderGetAccrued: %v", err)
+			}
+			if accrued != wantAccrued {
+				t.Fatalf("accrued = %d, want %d", accrued, wantAccrued)
+			}
+		})
+	}
+
+	balance, err := store.BalanceGetActual(ctx, customer)
+	if err != nil {
+		t.Fatalf("BalanceGetActual: %v", err)
+	}
+	if balance.Data.Balance != 110 {
+		t.Fatalf("balance = %d, want 110", balance.Data.Balance)
+	}
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/iurnickita/gophermart/internal/model"
+)
+
+func (store *store) PurchaseOrderGetAccrued(ctx context.Context, customer string) (int, error) {
+	//Сумма начислений за обработанные заказы без множителя уровня
+	var accrued int
+	row := store.database.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(base_accrual), 0)"+
+			" FROM purchase_order"+
+			" WHERE customer = $1"+
+			"   AND status = $2",
+		customer, model.PurchaseOrderStatusProcessed)
+	err := row.Scan(&accrued)
+	if err != nil {
+		return 0, err
+	}
+	return accrued, nil
+}
+
+func (store *store) TierGet(ctx context.Context, customer string) (model.CustomerTier, error) {
+	//Получение сохраненного уровня пользователя
+	customerTier := model.CustomerTier{Customer: customer}
+	row := store.database.QueryRowContext(ctx,
+		"SELECT tier, lifetime_accrued, updated_at"+
+			" FROM customer_tier"+
+			" WHERE customer = $1",
+		customer)
+	err := row.Scan(&customerTier.Data.Tier,
+		&customerTier.Data.LifetimeAccrued,
+		&customerTier.Data.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return model.CustomerTier{}, ErrNotFound
+		}
+		return model.CustomerTier{}, err
+	}
+	return customerTier, nil
+}
+
+func (store *store) TierPut(ctx context.Context, customerTier model.CustomerTier) error {
+	//Запись уровня пользователя
+	_, err := store.database.ExecContext(ctx,
+		"INSERT INTO customer_tier (customer, tier, lifetime_accrued, updated_at)"+
+			" VALUES ($1, $2, $3, $4)"+
+			" ON CONFLICT (customer) DO UPDATE"+
+			" SET tier = EXCLUDED.tier,"+
+			"     lifetime_accrued = EXCLUDED.lifetime_accrued,"+
+			"     updated_at = EXCLUDED.updated_at",
+		customerTier.Customer,
+		customerTier.Data.Tier,
+		customerTier.Data.LifetimeAccrued,
+		customerTier.Data.UpdatedAt)
+	return err
+}
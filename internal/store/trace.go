@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+var tracer = otel.Tracer("github.com/iurnickita/gophermart/internal/store")
+
+// tracedDB и tracedTx оборачивают *sql.DB и *sql.Tx, создавая span на каждый SQL-запрос.
+// На уровне debug каждый запрос также пишется в журнал
+type tracedDB struct {
+	*sql.DB
+	zaplog *zap.Logger
+}
+
+type tracedTx struct {
+	*sql.Tx
+	zaplog *zap.Logger
+}
+
+func (db *tracedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, q := startQuery(ctx, db.zaplog, query)
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	q.end(err)
+	return result, err
+}
+
+func (db *tracedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, q := startQuery(ctx, db.zaplog, query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	q.end(err)
+	return rows, err
+}
+
+func (db *tracedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, q := startQuery(ctx, db.zaplog, query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	q.end(row.Err())
+	return row
+}
+
+func (db *tracedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*tracedTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{Tx: tx, zaplog: db.zaplog}, nil
+}
+
+func (tx *tracedTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, q := startQuery(ctx, tx.zaplog, query)
+	result, err := tx.Tx.ExecContext(ctx, query, args...)
+	q.end(err)
+	return result, err
+}
+
+func (tx *tracedTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, q := startQuery(ctx, tx.zaplog, query)
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	q.end(err)
+	return rows, err
+}
+
+func (tx *tracedTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, q := startQuery(ctx, tx.zaplog, query)
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	q.end(row.Err())
+	return row
+}
+
+// queryObserver - span и запись журнала одного SQL-запроса
+type queryObserver struct {
+	span   trace.Span
+	zaplog *zap.Logger
+	query  string
+	start  time.Time
+}
+
+// startQuery начинает span запроса. Имя span - SQL-операция и таблица, напр. "SELECT balance"
+func startQuery(ctx context.Context, zaplog *zap.Logger, query string) (context.Context, *queryObserver) {
+	ctx, span := tracer.Start(ctx, querySpanName(query),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", query),
+		))
+	return ctx, &queryObserver{span: span, zaplog: zaplog, query: query, start: time.Now()}
+}
+
+func (q *queryObserver) end(err error) {
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	if err != nil {
+		q.span.RecordError(err)
+		q.span.SetStatus(codes.Error, err.Error())
+	}
+	q.span.End()
+
+	if ce := q.zaplog.Check(zap.DebugLevel, "sql query"); ce != nil {
+		ce.Write(
+			zap.String("query", q.query),
+			zap.Duration("duration", time.Since(q.start)),
+			zap.Error(err),
+		)
+	}
+}
+
+func querySpanName(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "sql"
+	}
+	operation := strings.ToUpper(fields[0])
+	// таблица следует за FROM, INTO или UPDATE
+	for i, field := range fields[:len(fields)-1] {
+		switch strings.ToUpper(field) {
+		case "FROM", "INTO", "UPDATE":
+			return operation + " " + strings.Trim(fields[i+1], "\"(")
+		}
+	}
+	return operation
+}
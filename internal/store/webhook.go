@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+
+	"github.com/iurnickita/gophermart/internal/model"
+)
+
+// WebhookPost регистрирует вебхук, если у пользователя их меньше maxPerCustomer,
+// иначе возвращает ErrLimitExceeded
+func (store *store) WebhookPost(ctx context.Context, webhook model.Webhook, maxPerCustomer int) (string, error) {
+	var id string
+	err := store.inTx(ctx, func(tx *tracedTx) error {
+		//Блокировка вебхуков пользователя: проверка количества и запись не должны пересекаться
+		_, err := tx.ExecContext(ctx,
+			"SELECT pg_advisory_xact_lock(hashtext('webhook'), hashtext($1))",
+			webhook.Data.Customer)
+		if err != nil {
+			return err
+		}
+
+		//Проверка количества вебхуков
+		var count int
+		row := tx.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM webhook"+
+				" WHERE customer = $1",
+			webhook.Data.Customer)
+		err = row.Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count >= maxPerCustomer {
+			return ErrLimitExceeded
+		}
+
+		//Регистрация вебхука
+		row = tx.QueryRowContext(ctx,
+			"INSERT INTO webhook (customer, url, secret, created_at)"+
+				" VALUES ($1, $2, $3, $4)"+
+				" RETURNING id",
+			webhook.Data.Customer,
+			webhook.Data.URL,
+			webhook.Data.Secret,
+			webhook.Data.CreatedAt)
+		return row.Scan(&id)
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (store *store) WebhookGet(ctx context.Context, customer string) ([]model.Webhook, error) {
+	//Получение вебхуков пользователя
+	rows, err := store.database.QueryContext(ctx,
+		"SELECT id, customer, url, secret, created_at"+
+			" FROM webhook"+
+			" WHERE customer = $1"+
+			" ORDER BY id",
+		customer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var webhooks []model.Webhook
+	for rows.Next() {
+		var webhook model.Webhook
+		err := rows.Scan(&webhook.ID,
+			&webhook.Data.Customer,
+			&webhook.Data.URL,
+			&webhook.Data.Secret,
+			&webhook.Data.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (store *store) WebhookDelete(ctx context.Context, customer string, id string) error {
+	//Удаление вебхука вместе с журналом доставок
+	return store.inTx(ctx, func(tx *tracedTx) error {
+		result, err := tx.ExecContext(ctx,
+			"DELETE FROM webhook"+
+				" WHERE id = $1"+
+				"   AND customer = $2",
+			id, customer)
+		if err != nil {
+			return err
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return ErrNotFound
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"DELETE FROM webhook_delivery"+
+				" WHERE webhook = $1",
+			id)
+		return err
+	})
+}
+
+func (store *store) WebhookDeliveryPost(ctx context.Context, delivery model.WebhookDelivery) error {
+	//Запись попытки доставки
+	_, err := store.database.ExecContext(ctx,
+		"INSERT INTO webhook_delivery (webhook, event, \"order\", attempt, status_code, error, success, timestamp)"+
+			" VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		delivery.Data.Webhook,
+		delivery.Data.Event,
+		delivery.Data.Order,
+		delivery.Data.Attempt,
+		delivery.Data.StatusCode,
+		delivery.Data.Error,
+		delivery.Data.Success,
+		delivery.Data.Timestamp)
+	return err
+}
+
+func (store *store) WebhookDeliverySucceeded(ctx context.Context, webhook string, event string, order string) (bool, error) {
+	//Проверка, что уведомление о заказе уже принято вебхуком
+	var delivered bool
+	row := store.database.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM webhook_delivery"+
+			" WHERE webhook = $1"+
+			"   AND event = $2"+
+			"   AND \"order\" = $3"+
+			"   AND success)",
+		webhook, event, order)
+	err := row.Scan(&delivered)
+	return delivered, err
+}
+
+func (store *store) WebhookDeliveryGet(ctx context.Context, customer string, webhook string) ([]model.WebhookDelivery, error) {
+	//Получение журнала доставок вебхука пользователя
+	rows, err := store.database.QueryContext(ctx,
+		"SELECT d.id, d.webhook, d.event, d.\"order\", d.attempt, d.status_code, d.error, d.success, d.timestamp"+
+			" FROM webhook_delivery AS d"+
+			" JOIN webhook AS w ON w.id = d.webhook"+
+			" WHERE w.customer = $1"+
+			"   AND w.id = $2"+
+			" ORDER BY d.id DESC",
+		customer, webhook)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []model.WebhookDelivery
+	for rows.Next() {
+		var delivery model.WebhookDelivery
+		err := rows.Scan(&delivery.ID,
+			&delivery.Data.Webhook,
+			&delivery.Data.Event,
+			&delivery.Data.Order,
+			&delivery.Data.Attempt,
+			&delivery.Data.StatusCode,
+			&delivery.Data.Error,
+			&delivery.Data.Success,
+			&delivery.Data.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,17 @@
+package config
+
+import "time"
+
+// Level - уровень программы лояльности.
+// Уровень присваивается, когда сумма всех начислений пользователя достигает Threshold.
+// Multiplier - множитель начислений в процентах (100 - без изменений)
+type Level struct {
+	Name       string
+	Threshold  int
+	Multiplier int
+}
+
+type Config struct {
+	Levels         []Level
+	RecalcInterval time.Duration
+}
@@ -0,0 +1,175 @@
+package tier
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/store"
+	"github.com/iurnickita/gophermart/internal/tier/config"
+	"go.uber.org/zap"
+)
+
+// Уровни программы лояльности.
+// Уровень рассчитывается по сумме начислений системы начислений за обработанные
+// заказы (без множителя, иначе уровень рос бы сам от себя) и периодически
+// пересчитывается для всех пользователей. Множитель уровня применяется к баллам,
+// начисляемым за заказы.
+
+type Tier interface {
+	Get(ctx context.Context, customer string) (Status, error)
+	Multiplier(ctx context.Context, customer string) (int, error)
+	Recalculate(ctx context.Context) (int, error)
+	Run(ctx context.Context)
+}
+
+// Status - текущий уровень пользователя и следующий уровень, если он есть
+type Status struct {
+	Level           config.Level
+	Next            *config.Level
+	LifetimeAccrued int
+	UpdatedAt       time.Time
+}
+
+var defaultLevels = []config.Level{
+	{Name: "Bronze", Threshold: 0, Multiplier: 100},
+	{Name: "Silver", Threshold: 1000, Multiplier: 105},
+	{Name: "Gold", Threshold: 5000, Multiplier: 110},
+}
+
+const defaultRecalcInterval = time.Hour
+
+type tier struct {
+	cfg    config.Config
+	store  store.Store
+	zaplog *zap.Logger
+}
+
+func NewTier(cfg config.Config, store store.Store, zaplog *zap.Logger) Tier {
+	if len(cfg.Levels) == 0 {
+		cfg.Levels = defaultLevels
+	}
+	levels := make([]config.Level, len(cfg.Levels))
+	copy(levels, cfg.Levels)
+	sort.Slice(levels, func(i, j int) bool {
+		return levels[i].Threshold < levels[j].Threshold
+	})
+	cfg.Levels = levels
+	if cfg.RecalcInterval <= 0 {
+		cfg.RecalcInterval = defaultRecalcInterval
+	}
+
+	return &tier{
+		cfg:    cfg,
+		store:  store,
+		zaplog: zaplog,
+	}
+}
+
+// Get возвращает сохраненный уровень пользователя.
+// Если уровень еще не рассчитывался, он рассчитывается и сохраняется.
+func (t *tier) Get(ctx context.Context, customer string) (Status, error) {
+	customerTier, err := t.store.TierGet(ctx, customer)
+	if err == store.ErrNotFound {
+		customerTier, err = t.recalculate(ctx, customer)
+	}
+	if err != nil {
+		return Status{}, err
+	}
+
+	return t.status(customerTier), nil
+}
+
+// Multiplier возвращает множитель начислений пользователя в процентах
+func (t *tier) Multiplier(ctx context.Context, customer string) (int, error) {
+	status, err := t.Get(ctx, customer)
+	if err != nil {
+		return 0, err
+	}
+	return status.Level.Multiplier, nil
+}
+
+// Recalculate пересчитывает уровни всех пользователей, возвращает количество пересчитанных
+func (t *tier) Recalculate(ctx context.Context) (int, error) {
+	customers, err := t.store.BalanceGetCustomers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i, customer := range customers {
+		_, err = t.recalculate(ctx, customer)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(customers), nil
+}
+
+// Run периодически пересчитывает уровни до отмены контекста
+func (t *tier) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.cfg.RecalcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			count, err := t.Recalculate(ctx)
+			if err != nil {
+				t.zaplog.Error("tier recalculation failed", zap.Int("recalculated", count), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (t *tier) recalculate(ctx context.Context, customer string) (model.CustomerTier, error) {
+	accrued, err := t.store.PurchaseOrderGetAccrued(ctx, customer)
+	if err != nil {
+		return model.CustomerTier{}, err
+	}
+
+	customerTier := model.CustomerTier{
+		Customer: customer,
+		Data: model.CustomerTierData{
+			Tier:            t.level(accrued).Name,
+			LifetimeAccrued: accrued,
+			UpdatedAt:       time.Now()}}
+	err = t.store.TierPut(ctx, customerTier)
+	if err != nil {
+		return model.CustomerTier{}, err
+	}
+	return customerTier, nil
+}
+
+func (t *tier) status(customerTier model.CustomerTier) Status {
+	status := Status{
+		Level:           t.level(customerTier.Data.LifetimeAccrued),
+		LifetimeAccrued: customerTier.Data.LifetimeAccrued,
+		UpdatedAt:       customerTier.Data.UpdatedAt,
+	}
+	// уровень берется из сохраненного значения, пока его не пересчитали
+	for _, level := range t.cfg.Levels {
+		if level.Name == customerTier.Data.Tier {
+			status.Level = level
+		}
+	}
+	for _, level := range t.cfg.Levels {
+		if level.Threshold > status.Level.Threshold {
+			next := level
+			status.Next = &next
+			break
+		}
+	}
+	return status
+}
+
+// level - наибольший уровень, порог которого достигнут
+func (t *tier) level(accrued int) config.Level {
+	current := t.cfg.Levels[0]
+	for _, level := range t.cfg.Levels {
+		if accrued >= level.Threshold {
+			current = level
+		}
+	}
+	return current
+}
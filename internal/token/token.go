@@ -1,6 +1,10 @@
 package token
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,6 +16,8 @@ type Claims struct {
 	UserCode string
 }
 
+var ErrInvalidToken = errors.New("token is not valid")
+
 const TOKEN_EXP = time.Hour * 3
 const secretKey = "supersecretkey"
 
@@ -49,11 +55,27 @@ func GetUserCode(tokenString string) (string, error) {
 		return "", err
 	}
 
-	if !token.Valid {
-		fmt.Println("Token is not valid")
-		return "", err
+	if !token.Valid || claims.UserCode == "" {
+		return "", ErrInvalidToken
 	}
 
-	fmt.Println("Token os valid")
 	return claims.UserCode, nil
 }
+
+// NewAdminToken создает случайный токен администратора и его хеш для хранения в БД
+func NewAdminToken() (string, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	adminToken := hex.EncodeToString(b)
+	return adminToken, AdminTokenHash(adminToken), nil
+}
+
+// AdminTokenHash - хеш токена администратора. Токен случайный и длинный,
+// поэтому медленный хеш вроде bcrypt не нужен и поиск выполняется по хешу
+func AdminTokenHash(adminToken string) string {
+	sum := sha256.Sum256([]byte(adminToken))
+	return hex.EncodeToString(sum[:])
+}
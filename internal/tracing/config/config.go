@@ -0,0 +1,12 @@
+package config
+
+type Config struct {
+	// Экспорт трасс: "" или "none" - отключен, "stdout" - в стандартный вывод, "otlp" - по OTLP/HTTP
+	Exporter string
+	// Адрес OTLP-коллектора (host:port), по умолчанию из переменных окружения OTEL_EXPORTER_OTLP_*
+	OTLPEndpoint string
+	OTLPInsecure bool
+	ServiceName  string
+	// Доля сэмплируемых трасс от 0 до 1, 0 - все трассы
+	SampleRatio float64
+}
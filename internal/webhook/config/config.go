@@ -0,0 +1,14 @@
+package config
+
+import "time"
+
+type Config struct {
+	// Таймаут одного запроса к адресу вебхука.
+	// Повторы доставки выполняет диспетчер outbox (MaxAttempts, RetryBackoff в outbox/config)
+	Timeout time.Duration
+	// Ограничение количества вебхуков на пользователя
+	MaxPerCustomer int
+	// Разрешить адреса loopback и частных сетей. Только для разработки:
+	// иначе пользователь может отправлять запросы во внутреннюю сеть сервиса
+	AllowPrivateNetworks bool
+}
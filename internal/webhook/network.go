@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// Защита от SSRF.
+// Адрес вебхука задает пользователь, поэтому запросы во внутреннюю сеть сервиса запрещены:
+// адрес проверяется при регистрации, а IP-адрес соединения - при каждом подключении
+// (DNS-запись могла измениться после регистрации). Переадресации не выполняются.
+
+var errForbiddenAddress = errors.New("webhook address is not allowed")
+
+// forbiddenAddr - loopback, частные, link-local, multicast и неопределенные адреса
+func forbiddenAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() ||
+		addr.IsUnspecified()
+}
+
+// checkHost проверяет все адреса, в которые разрешается имя хоста
+func (wh *webhook) checkHost(ctx context.Context, host string) error {
+	if wh.cfg.AllowPrivateNetworks {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if forbiddenAddr(addr) {
+			return errForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// newTransport - транспорт без прокси, проверяющий адрес каждого соединения
+func newTransport(dialTimeout time.Duration, allowPrivate bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(_ string, address string, _ syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if forbiddenAddr(addrPort.Addr()) {
+				return errForbiddenAddress
+			}
+			return nil
+		},
+	}
+	return &http.Transport{
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+}
@@ -0,0 +1,269 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/iurnickita/gophermart/internal/model"
+	"github.com/iurnickita/gophermart/internal/store"
+	"github.com/iurnickita/gophermart/internal/webhook/config"
+)
+
+// Вебхуки пользователей.
+// Пользователь регистрирует URL, на который отправляются JSON-уведомления
+// о переходе его заказов в конечный статус (PROCESSED или INVALID).
+// Тело запроса подписывается HMAC-SHA256 секретом вебхука, подпись передается
+// в заголовке X-Gophermart-Signature в виде "sha256=<hex>".
+// Уведомления отправляются приемником outbox (Send) по событию OrderStatusChanged,
+// поэтому повторы переживают перезапуск сервиса. Вебхук, уже принявший уведомление
+// о заказе, при повторе события пропускается.
+
+type Webhook interface {
+	Register(ctx context.Context, customer string, rawURL string) (model.Webhook, error)
+	List(ctx context.Context, customer string) ([]model.Webhook, error)
+	Delete(ctx context.Context, customer string, id string) error
+	Deliveries(ctx context.Context, customer string, id string) ([]model.WebhookDelivery, error)
+	Ping(ctx context.Context, customer string, id string) (model.WebhookDelivery, error)
+	// Name и Send - приемник outbox.Sink
+	Name() string
+	Send(ctx context.Context, event model.Event) error
+}
+
+var (
+	ErrInvalidURL   = errors.New("invalid webhook url")
+	ErrTooManyHooks = errors.New("too many webhooks")
+	ErrNotFound     = errors.New("webhook not found")
+)
+
+const (
+	HeaderSignature = "X-Gophermart-Signature"
+	HeaderEvent     = "X-Gophermart-Event"
+	HeaderDelivery  = "X-Gophermart-Delivery"
+
+	defaultTimeout        = 10 * time.Second
+	defaultMaxPerCustomer = 5
+)
+
+// JSON тело уведомления
+type PayloadJSON struct {
+	Event     string    `json:"event"`
+	Order     string    `json:"order,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Accrual   int       `json:"accrual,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type webhook struct {
+	cfg    config.Config
+	store  store.Store
+	client *resty.Client
+}
+
+func NewWebhook(cfg config.Config, store store.Store) Webhook {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxPerCustomer <= 0 {
+		cfg.MaxPerCustomer = defaultMaxPerCustomer
+	}
+
+	return &webhook{
+		cfg:   cfg,
+		store: store,
+		client: resty.New().
+			SetTimeout(cfg.Timeout).
+			SetTransport(newTransport(cfg.Timeout, cfg.AllowPrivateNetworks)).
+			SetRedirectPolicy(resty.NoRedirectPolicy()),
+	}
+}
+
+func (wh *webhook) Register(ctx context.Context, customer string, rawURL string) (model.Webhook, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return model.Webhook{}, ErrInvalidURL
+	}
+	// адреса внутренней сети запрещены, неразрешимое имя тоже считается ошибкой
+	if wh.checkHost(ctx, parsed.Hostname()) != nil {
+		return model.Webhook{}, ErrInvalidURL
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return model.Webhook{}, err
+	}
+
+	newWebhook := model.Webhook{
+		Data: model.WebhookData{
+			Customer:  customer,
+			URL:       parsed.String(),
+			Secret:    secret,
+			CreatedAt: time.Now()}}
+	newWebhook.ID, err = wh.store.WebhookPost(ctx, newWebhook, wh.cfg.MaxPerCustomer)
+	if err != nil {
+		if err == store.ErrLimitExceeded {
+			return model.Webhook{}, ErrTooManyHooks
+		}
+		return model.Webhook{}, err
+	}
+	return newWebhook, nil
+}
+
+func (wh *webhook) List(ctx context.Context, customer string) ([]model.Webhook, error) {
+	return wh.store.WebhookGet(ctx, customer)
+}
+
+func (wh *webhook) Delete(ctx context.Context, customer string, id string) error {
+	err := wh.store.WebhookDelete(ctx, customer, id)
+	if err == store.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (wh *webhook) Deliveries(ctx context.Context, customer string, id string) ([]model.WebhookDelivery, error) {
+	if _, err := wh.get(ctx, customer, id); err != nil {
+		return nil, err
+	}
+	return wh.store.WebhookDeliveryGet(ctx, customer, id)
+}
+
+// Ping отправляет тестовое уведомление один раз, без повторов, и возвращает результат попытки
+func (wh *webhook) Ping(ctx context.Context, customer string, id string) (model.WebhookDelivery, error) {
+	target, err := wh.get(ctx, customer, id)
+	if err != nil {
+		return model.WebhookDelivery{}, err
+	}
+
+	payload := PayloadJSON{Event: model.WebhookEventPing, Timestamp: time.Now()}
+	deliveryID := fmt.Sprintf("%s-ping-%d", target.ID, payload.Timestamp.UnixNano())
+	return wh.attempt(ctx, target, payload, deliveryID, 1)
+}
+
+// Name - имя приемника outbox
+func (wh *webhook) Name() string {
+	return "customer_webhooks"
+}
+
+// Send уведомляет все вебхуки владельца заказа о переходе заказа в конечный статус.
+// Ошибка доставки хотя бы одному вебхуку возвращается диспетчеру outbox, и событие повторяется
+// только для вебхуков пользователей: вебхуки, уже принявшие событие, пропускаются
+func (wh *webhook) Send(ctx context.Context, event model.Event) error {
+	if event.Data.Type != model.EventOrderStatusChanged {
+		return nil
+	}
+	switch event.Data.Status {
+	case model.PurchaseOrderStatusProcessed, model.PurchaseOrderStatusInvalid:
+	default:
+		return nil
+	}
+
+	webhooks, err := wh.store.WebhookGet(ctx, event.Data.Customer)
+	if err != nil {
+		return err
+	}
+	payload := PayloadJSON{
+		Event:     model.WebhookEventOrderStatus,
+		Order:     event.Data.Order,
+		Status:    event.Data.Status,
+		Accrual:   event.Data.Points,
+		Timestamp: event.Data.CreatedAt}
+	failed := 0
+	for _, target := range webhooks {
+		delivered, err := wh.store.WebhookDeliverySucceeded(ctx, target.ID, payload.Event, payload.Order)
+		if err != nil {
+			return err
+		}
+		if delivered {
+			continue
+		}
+		// идентификатор доставки одинаков во всех попытках: получатель может отбросить дубликат
+		deliveryID := fmt.Sprintf("%s-%s", target.ID, event.ID)
+		delivery, err := wh.attempt(ctx, target, payload, deliveryID, event.Attempts+1)
+		if err != nil {
+			return err
+		}
+		if !delivery.Data.Success {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("webhook delivery failed: %d of %d webhooks", failed, len(webhooks))
+	}
+	return nil
+}
+
+// attempt выполняет одну попытку доставки и записывает ее в журнал
+func (wh *webhook) attempt(ctx context.Context, target model.Webhook, payload PayloadJSON, deliveryID string, attempt int) (model.WebhookDelivery, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return model.WebhookDelivery{}, err
+	}
+
+	delivery := model.WebhookDelivery{
+		Data: model.WebhookDeliveryData{
+			Webhook:   target.ID,
+			Event:     payload.Event,
+			Order:     payload.Order,
+			Attempt:   attempt,
+			Timestamp: time.Now()}}
+
+	resp, err := wh.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader(HeaderEvent, payload.Event).
+		SetHeader(HeaderDelivery, deliveryID).
+		SetHeader(HeaderSignature, Sign(target.Data.Secret, body)).
+		SetBody(body).
+		Post(target.Data.URL)
+	if err != nil {
+		delivery.Data.Error = err.Error()
+	} else {
+		delivery.Data.StatusCode = resp.StatusCode()
+		delivery.Data.Success = resp.StatusCode() >= http.StatusOK && resp.StatusCode() < http.StatusMultipleChoices
+	}
+
+	err = wh.store.WebhookDeliveryPost(ctx, delivery)
+	if err != nil {
+		return delivery, err
+	}
+	return delivery, nil
+}
+
+func (wh *webhook) get(ctx context.Context, customer string, id string) (model.Webhook, error) {
+	webhooks, err := wh.store.WebhookGet(ctx, customer)
+	if err != nil {
+		return model.Webhook{}, err
+	}
+	for _, webhook := range webhooks {
+		if webhook.ID == id {
+			return webhook, nil
+		}
+	}
+	return model.Webhook{}, ErrNotFound
+}
+
+// Sign возвращает значение заголовка подписи для тела уведомления
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}